@@ -10,11 +10,10 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/jung-kurt/gofpdf/contrib/barcode"
-
 	"github.com/jung-kurt/gofpdf"
 )
 
@@ -25,8 +24,43 @@ var (
 	logP   = stdLog.Println
 )
 
+// PrintNode printer IDs are set in env by what is printed, as a roll
+// label printer can't take sheets or ZPL.
+const (
+	envLabelPrinter = "PRINT_LABEL_PRINTER"
+	envSheetPrinter = "PRINT_SHEET_PRINTER"
+	envZPLPrinter   = "PRINT_ZPL_PRINTER"
+
+	// defLabelPrinter is the roll label printer.
+	defLabelPrinter = 434362
+)
+
 type publishRequest struct {
-	Codes []string
+	// Codes are plain codes, printed with the code template unless
+	// Template says otherwise. Each is a code string or {"Code", "Qt"}.
+	Codes []codeEntry
+	// Labels are codes with their title, condition and extra lines.
+	Labels []label
+	// Template is used for labels that don't name one; it defaults to
+	// fnsku for Labels.
+	Template string
+	// Symbology is used for labels that don't name one; it defaults to
+	// code128.
+	Symbology string
+	// Sheet tiles the labels onto a label sheet instead of one page
+	// a label.
+	Sheet *sheetRequest
+	// NewOrder is the Order function's NewOrder, brand then SKU. Each
+	// SKU is printed Qt times, a brand at a time after a separator page.
+	// BatchCode is "sku" (the default) or "upc" for the barcode.
+	NewOrder  map[string]map[string]orderItem
+	BatchCode string
+	// Format is "pdf" (the default) or "zpl" for Zebra printers, made
+	// for a DPI of 203 (the default) or 300. Sheets are pdf only.
+	Format string
+	DPI    int
+	// Print sends the output to the PrintNode printer for its format,
+	// see printer.
 	Print bool
 	Title string
 }
@@ -39,6 +73,9 @@ type returnAPI struct {
 	Contnet string
 	Print   bool
 	Message string
+	// Errors are the codes that can't be printed; no pdf is made when
+	// there are any.
+	Errors []codeError `json:",omitempty"`
 }
 
 type postPrintNode struct {
@@ -68,13 +105,64 @@ func Barcoder(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error parsing request", http.StatusBadRequest)
 		return
 	}
-	logP("Making Barcodes and pdf")
-	j, err := makeBarcodes(p.Codes, p.Title, p.Print)
+	groups, err := p.groups()
 	if err != nil {
-		errLog.Println("makeBarcodes:", err)
-		http.Error(w, "Error making PDF", http.StatusBadRequest)
+		errLog.Println("groups:", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errs := checkCodes(groups); len(errs) > 0 {
+		errLog.Println("checkCodes:", len(errs), "invalid codes")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&returnAPI{Message: "Invalid codes; no PDF made.", Errors: errs})
 		return
 	}
+	if err := p.checkFormat(); err != nil {
+		errLog.Println("checkFormat:", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if p.Format == formatPDF {
+		if err := checkQt(groups); err != nil {
+			errLog.Println("checkQt:", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	printer := 0
+	if p.Print {
+		printer, err = p.printer()
+		if err != nil {
+			errLog.Println("printer:", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var j returnAPI
+	if p.Format == formatZPL {
+		logP("Making ZPL")
+		zpl, err := makeZPL(groups, p.DPI)
+		if err != nil {
+			errLog.Println("makeZPL:", err)
+			http.Error(w, "Error making ZPL", http.StatusBadRequest)
+			return
+		}
+		j, err = deliver([]byte(zpl), formatZPL, p.Title, printer)
+		if err != nil {
+			errLog.Println("deliver:", err)
+			http.Error(w, "Error printing ZPL", http.StatusBadRequest)
+			return
+		}
+	} else {
+		logP("Making Barcodes and pdf")
+		j, err = makeBarcodes(groups, p.Sheet, p.Title, printer)
+		if err != nil {
+			errLog.Println("makeBarcodes:", err)
+			http.Error(w, "Error making PDF", http.StatusBadRequest)
+			return
+		}
+	}
 
 	json.NewEncoder(w).Encode(&j)
 	logP("sent!")
@@ -106,7 +194,106 @@ func authRequest(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
-func makeBarcodes(codes []string, title string, print bool) (returnAPI, error) {
+// checkFormat fills in and checks Format and DPI.
+func (p *publishRequest) checkFormat() error {
+	switch p.Format {
+	case "":
+		p.Format = formatPDF
+	case formatPDF:
+	case formatZPL:
+		if p.Sheet != nil {
+			return errors.New("sheets can't be printed as " + formatZPL)
+		}
+		if p.DPI == 0 {
+			p.DPI = 203
+		}
+		if !zplDPIs[p.DPI] {
+			return errors.New("DPI must be 203 or 300, got " + strconv.Itoa(p.DPI))
+		}
+	default:
+		return errors.New(`format must be "` + formatPDF + `" or "` + formatZPL + `", got "` + p.Format + `"`)
+	}
+	return nil
+}
+
+// printer picks the PrintNode printer for p's output from env. Label
+// sheets and ZPL each need their own printer set; roll labels go to
+// defLabelPrinter when PRINT_LABEL_PRINTER is not set.
+func (p publishRequest) printer() (int, error) {
+	env, def := envLabelPrinter, defLabelPrinter
+	switch {
+	case p.Format == formatZPL:
+		env, def = envZPLPrinter, 0
+	case p.Sheet != nil:
+		env, def = envSheetPrinter, 0
+	}
+	v := os.Getenv(env)
+	if v == "" {
+		if def == 0 {
+			return 0, errors.New("can't print: " + env + " env is not set")
+		}
+		return def, nil
+	}
+	id, err := strconv.Atoi(v)
+	if err != nil || id <= 0 {
+		return 0, errors.New(env + " must be a PrintNode printer ID, got " + v)
+	}
+	return id, nil
+}
+
+// groups puts Codes and Labels together in one group with no
+// separator, followed by a group a brand from NewOrder.
+func (p publishRequest) groups() ([]labelGroup, error) {
+	groups := []labelGroup{}
+	if labels := p.labels(); len(labels) > 0 {
+		groups = append(groups, labelGroup{Labels: labels})
+	}
+	if len(p.NewOrder) > 0 {
+		tmpl := p.Template
+		if tmpl == "" {
+			tmpl = tmplFNSKU
+		}
+		batch, err := batchGroups(p.NewOrder, p.BatchCode, tmpl)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, batch...)
+	}
+	for _, g := range groups {
+		for i := range g.Labels {
+			if g.Labels[i].Symbology == "" {
+				g.Labels[i].Symbology = p.Symbology
+			}
+		}
+	}
+	return groups, nil
+}
+
+// labels puts Codes and Labels together, each with its template name.
+func (p publishRequest) labels() []label {
+	labels := []label{}
+	for _, c := range p.Codes {
+		l := label{Code: c.Code, Qt: c.Qt, Symbology: c.Symbology, Template: p.Template}
+		if l.Template == "" {
+			l.Template = tmplCode
+		}
+		labels = append(labels, l)
+	}
+	for _, l := range p.Labels {
+		if l.Template == "" {
+			l.Template = p.Template
+		}
+		if l.Template == "" {
+			l.Template = tmplFNSKU
+		}
+		labels = append(labels, l)
+	}
+	return labels
+}
+
+// makeBarcodes draws each group, after its separator page, and sends
+// the pdf back or, when printer is set, to PrintNode.
+func makeBarcodes(groups []labelGroup, sheet *sheetRequest, title string, printer int) (returnAPI, error) {
 	ret := returnAPI{}
 
 	initOp := &gofpdf.InitType{
@@ -118,11 +305,40 @@ func makeBarcodes(codes []string, title string, print bool) (returnAPI, error) {
 	}
 
 	pdf := gofpdf.NewCustom(initOp)
-	for _, code := range codes {
-		err := addCode(code, pdf)
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	if sheet != nil {
+		sl, err := sheet.layout()
 		if err != nil {
 			return ret, err
 		}
+		// Start only skips labels on the first sheet printed.
+		start := sheet.Start
+		for _, g := range groups {
+			if g.Name != "" {
+				addSeparator(pdf, sl.PageWd, sl.PageHt, g)
+			}
+			if err := tileSheet(pdf, sl, start, repeat(g.Labels)); err != nil {
+				return ret, err
+			}
+			start = 0
+		}
+	} else {
+		for _, g := range groups {
+			if g.Name != "" {
+				t, err := findTemplate(g.Labels[0].Template)
+				if err != nil {
+					return ret, errors.New(g.Name + ": " + err.Error())
+				}
+				addSeparator(pdf, t.Wd, t.Ht, g)
+			}
+			for _, l := range repeat(g.Labels) {
+				err := addLabel(l, pdf)
+				if err != nil {
+					return ret, err
+				}
+			}
+		}
 	}
 
 	buf := bytes.Buffer{}
@@ -137,45 +353,55 @@ func makeBarcodes(codes []string, title string, print bool) (returnAPI, error) {
 		return ret, err
 	}
 	println(len(b))
-	pdfBase64Str := base64.StdEncoding.EncodeToString(b)
-	if print {
-		err := sendToPrintNode(pdfBase64Str, title)
+	return deliver(b, formatPDF, title, printer)
+}
+
+// deliver sends b, in format pdf or zpl, to PrintNode printer when one
+// is given, or back base64 encoded.
+func deliver(b []byte, format, title string, printer int) (returnAPI, error) {
+	ret := returnAPI{}
+	base64Str := base64.StdEncoding.EncodeToString(b)
+	if printer != 0 {
+		contentType := "pdf_base64"
+		if format == formatZPL {
+			contentType = "raw_base64"
+		}
+		err := sendToPrintNode(printer, base64Str, contentType, title)
 		if err != nil {
 			return ret, err
 		}
 
-		ret.Print = print
+		ret.Print = true
 		ret.Message = "Print successful."
 		return ret, nil
 	}
-	ret.Print = print
-	ret.Message = "Base64 pdf string sent."
-	ret.Contnet = pdfBase64Str
+	ret.Message = "Base64 " + format + " string sent."
+	ret.Contnet = base64Str
 	return ret, nil
 }
 
-// addCode adds code to pdf.
-func addCode(code string, pdf *gofpdf.Fpdf) error {
-	pdf.AddPage()
-	pdf.SetFont("Arial", "", 18)
-	pdf.CellFormat(0, -.2, code, "", 0, "C", false, 0, "")
-
-	key := barcode.RegisterCode128(pdf, code)
-	width := 3.0
-	height := 1.0
-	barcode.BarcodeUnscalable(pdf, key, 0, 0.4, &width, &height, false)
+// addLabel adds l to pdf on a page the size of its template.
+func addLabel(l label, pdf *gofpdf.Fpdf) error {
+	t, err := findTemplate(l.Template)
+	if err != nil {
+		return errors.New(l.Code + ": " + err.Error())
+	}
+	pdf.AddPageFormat("P", gofpdf.SizeType{Wd: t.Wd, Ht: t.Ht})
+	drawPDF(pdf, 0, 0, t.layout(l, pdfMeasure(pdf)))
 	return nil
 }
 
-func sendToPrintNode(pdfStr, title string) error {
+// sendToPrintNode submits a base64 print job to printer; contentType is
+// pdf_base64 or raw_base64 for ZPL.
+func sendToPrintNode(printer int, content, contentType, title string) error {
 	key := os.Getenv("PRINT_API_KEY")
 	secret := os.Getenv("PRINT_API_SECRET")
 
 	ok := postPrintNode{
-		PrinterID:   434362,
+		PrinterID:   printer,
 		Title:       title,
-		ContentType: "pdf_base64",
-		Content:     pdfStr,
+		ContentType: contentType,
+		Content:     content,
 		Source:      "FBA-Stock print processing FBA",
 	}
 
@@ -0,0 +1,178 @@
+package barcoder
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// codeEntry is a Codes entry: a plain code string, or an object with
+// the code, how many labels to print and its symbology.
+type codeEntry struct {
+	Code      string
+	Qt        int
+	Symbology string
+}
+
+func (ce *codeEntry) UnmarshalJSON(b []byte) error {
+	var code string
+	if err := json.Unmarshal(b, &code); err == nil {
+		ce.Code, ce.Qt = code, 1
+		return nil
+	}
+	type entry codeEntry
+	e := entry{}
+	if err := json.Unmarshal(b, &e); err != nil {
+		return err
+	}
+	*ce = codeEntry(e)
+	return nil
+}
+
+// orderItem is an item of the Order function's NewOrder.
+type orderItem struct {
+	SKU      string
+	UPC      string
+	Qt       int
+	Title    string
+	Location string
+}
+
+// Codes used for batch labels, set by BatchCode.
+const (
+	batchSKU = "sku"
+	batchUPC = "upc"
+)
+
+// labelGroup is labels printed together after a separator page naming
+// the group. Labels with no group name get no separator.
+type labelGroup struct {
+	Name   string
+	Labels []label
+}
+
+// maxQt is the most copies of one label a pdf is made with. Each copy
+// is a page or sheet cell, so larger counts should be printed as ZPL,
+// where the printer repeats the label.
+const maxQt = 1000
+
+// checkQt checks no label in groups is to be printed more than maxQt
+// times.
+func checkQt(groups []labelGroup) error {
+	over := []string{}
+	for _, g := range groups {
+		for _, l := range g.Labels {
+			if l.Qt > maxQt {
+				over = append(over, l.Code+" ("+strconv.Itoa(l.Qt)+")")
+			}
+		}
+	}
+	if len(over) > 0 {
+		return errors.New("Qt is over " + strconv.Itoa(maxQt) + " for " + strings.Join(over, ", ") + "; use zpl for more")
+	}
+	return nil
+}
+
+// repeat lists each label as many times as it is to be printed.
+func repeat(labels []label) []label {
+	out := []label{}
+	for _, l := range labels {
+		for i := 0; i < l.qt(); i++ {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// batchGroups turns an Order NewOrder into one group a brand, brands
+// and SKUs in order, each SKU printed Qt times; items with no Qt are
+// left out. code picks the SKU or UPC for the barcode; items with no
+// UPC are an error in upc mode.
+func batchGroups(newOrder map[string]map[string]orderItem, code, template string) ([]labelGroup, error) {
+	if code == "" {
+		code = batchSKU
+	}
+	if code != batchSKU && code != batchUPC {
+		return nil, errors.New(`batch code must be "` + batchSKU + `" or "` + batchUPC + `", got "` + code + `"`)
+	}
+
+	brands := []string{}
+	for b := range newOrder {
+		brands = append(brands, b)
+	}
+	sort.Strings(brands)
+
+	groups := []labelGroup{}
+	noUPC := []string{}
+	for _, brand := range brands {
+		skus := []string{}
+		for sku := range newOrder[brand] {
+			skus = append(skus, sku)
+		}
+		sort.Strings(skus)
+
+		g := labelGroup{Name: brand}
+		for _, sku := range skus {
+			it := newOrder[brand][sku]
+			if it.Qt <= 0 {
+				continue
+			}
+			if it.SKU == "" {
+				it.SKU = sku
+			}
+			l := label{Code: it.SKU, Title: it.Title, Qt: it.Qt, Template: template}
+			if code == batchUPC {
+				if it.UPC == "" {
+					noUPC = append(noUPC, it.SKU)
+					continue
+				}
+				l.Code = it.UPC
+			}
+			if it.Location != "" {
+				l.Lines = []string{it.Location}
+			}
+			g.Labels = append(g.Labels, l)
+		}
+		if len(g.Labels) > 0 {
+			groups = append(groups, g)
+		}
+	}
+	if len(noUPC) > 0 {
+		return nil, errors.New("no UPC for " + strings.Join(noUPC, ", "))
+	}
+	return groups, nil
+}
+
+// summary is how many SKUs and labels are in g, for its separator.
+func (g labelGroup) summary() string {
+	skus := map[string]bool{}
+	count := 0
+	for _, l := range g.Labels {
+		skus[l.Code] = true
+		count += l.qt()
+	}
+	return strconv.Itoa(len(skus)) + " SKUs, " + strconv.Itoa(count) + " labels"
+}
+
+// separatorSize is the font size of the group name on an h tall
+// separator; the summary is half that.
+func separatorSize(h float64) float64 {
+	return math.Min(48, 24*h/1.5)
+}
+
+// addSeparator adds a w×h page naming group g, its SKUs and label count.
+func addSeparator(pdf *gofpdf.Fpdf, w, h float64, g labelGroup) {
+	pdf.AddPageFormat("P", gofpdf.SizeType{Wd: w, Ht: h})
+	size := separatorSize(h)
+	pdf.SetFont("Arial", "B", size)
+	pdf.SetXY(0, h/2-lineHt(size))
+	pdf.CellFormat(w, lineHt(size), g.Name, "", 0, "C", false, 0, "")
+	pdf.SetFont("Arial", "", size/2)
+	pdf.SetXY(0, h/2+lineHt(size)/4)
+	pdf.CellFormat(w, lineHt(size/2), g.summary(), "", 0, "C", false, 0, "")
+}
@@ -0,0 +1,104 @@
+package barcoder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckQt(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups []labelGroup
+		err    string
+	}{
+		{name: "none"},
+		{name: "at max", groups: []labelGroup{{Labels: []label{{Code: "A", Qt: maxQt}}}}},
+		{
+			name:   "over max",
+			groups: []labelGroup{{Name: "b1", Labels: []label{{Code: "A", Qt: 5}}}, {Name: "b2", Labels: []label{{Code: "B", Qt: maxQt + 1}, {Code: "C", Qt: 2000}}}},
+			err:    "Qt is over 1000 for B (1001), C (2000); use zpl for more",
+		},
+	}
+	for _, tt := range tests {
+		err := checkQt(tt.groups)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: checkQt = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestBatchGroups(t *testing.T) {
+	order := map[string]map[string]orderItem{
+		"Zeta": {
+			"Z1": {UPC: "036000291452", Qt: 2, Title: "Zed"},
+		},
+		"Acme": {
+			"A2": {SKU: "A2", UPC: "012345678905", Qt: 1, Location: "Bin 4"},
+			"A1": {UPC: "4006381333931", Qt: 3},
+			"A3": {UPC: "5901234123457", Qt: 0},
+		},
+		"Empty": {
+			"E1": {Qt: 0},
+		},
+	}
+	tests := []struct {
+		name  string
+		order map[string]map[string]orderItem
+		code  string
+		want  []labelGroup
+		err   string
+	}{
+		{
+			name:  "sku",
+			order: order,
+			want: []labelGroup{
+				{Name: "Acme", Labels: []label{
+					{Code: "A1", Qt: 3, Template: tmplFNSKU},
+					{Code: "A2", Qt: 1, Template: tmplFNSKU, Lines: []string{"Bin 4"}},
+				}},
+				{Name: "Zeta", Labels: []label{{Code: "Z1", Title: "Zed", Qt: 2, Template: tmplFNSKU}}},
+			},
+		},
+		{
+			name:  "upc",
+			order: order,
+			code:  batchUPC,
+			want: []labelGroup{
+				{Name: "Acme", Labels: []label{
+					{Code: "4006381333931", Qt: 3, Template: tmplFNSKU},
+					{Code: "012345678905", Qt: 1, Template: tmplFNSKU, Lines: []string{"Bin 4"}},
+				}},
+				{Name: "Zeta", Labels: []label{{Code: "036000291452", Title: "Zed", Qt: 2, Template: tmplFNSKU}}},
+			},
+		},
+		{
+			name:  "upc missing",
+			order: map[string]map[string]orderItem{"Acme": {"A1": {Qt: 1}, "A2": {Qt: 1, UPC: "012345678905"}, "A3": {Qt: 1}}},
+			code:  batchUPC,
+			err:   "no UPC for A1, A3",
+		},
+		{
+			name:  "bad code",
+			order: order,
+			code:  "fnsku",
+			err:   `batch code must be "sku" or "upc", got "fnsku"`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := batchGroups(tt.order, tt.code, tmplFNSKU)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: batchGroups =\n%+v\nwant\n%+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Command zplview renders the fields of Barcoder ZPL so their positions
+// can be checked without a Zebra printer.
+//
+// It reads ZPL, or the base64 Contnet of a Barcoder response with -b64,
+// from a file or stdin. Each label's fields are listed in dots and
+// inches, and any that run off the ^PW×^LL label are marked "OFF"; the
+// exit status is 1 when there are any. With -pdf each label is drawn
+// as a page, barcodes encoded as the printer would and text in boxes.
+//
+//	zplview -dpi 300 -pdf labels.pdf labels.zpl
+//
+// Barcodes are sized by encoding them again with the same boombuler
+// encoders Barcoder uses, so zplview only shows Barcoder agrees with
+// itself, not with a printer. It can't catch where Zebra firmware does
+// differ: ^BQ adds a top offset above the QR code, and ^BC picks its
+// own Code128 subsets so the width can differ from boombuler's. Check
+// new layouts on a printer or a ZPL viewer too.
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/code39"
+	"github.com/boombuler/barcode/datamatrix"
+	"github.com/boombuler/barcode/ean"
+	"github.com/boombuler/barcode/qr"
+	"github.com/jung-kurt/gofpdf"
+	pdfbarcode "github.com/jung-kurt/gofpdf/contrib/barcode"
+)
+
+// field is one ^FO...^FS field, in dots.
+type field struct {
+	Kind       string
+	X, Y, W, H int
+	Text       string
+	Align      string
+	bc         barcode.Barcode
+}
+
+// zplLabel is one ^XA...^XZ format.
+type zplLabel struct {
+	Wd, Ht int
+	Qt     int
+	Fields []field
+}
+
+func main() {
+	dpi := flag.Int("dpi", 203, "print head dots an inch")
+	b64 := flag.Bool("b64", false, "input is base64, as in a Barcoder response")
+	pdfOut := flag.String("pdf", "", "draw the labels to this pdf")
+	flag.Parse()
+
+	var b []byte
+	var err error
+	if flag.NArg() > 0 {
+		b, err = ioutil.ReadFile(flag.Arg(0))
+	} else {
+		b, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err == nil && *b64 {
+		b, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	labels, err := parse(string(b))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	in := func(d int) string { return strconv.FormatFloat(float64(d)/float64(*dpi), 'f', 3, 64) }
+	off := 0
+	for n, l := range labels {
+		fmt.Printf("label %d: %d×%d dots (%s×%sin) ×%d\n", n+1, l.Wd, l.Ht, in(l.Wd), in(l.Ht), l.Qt)
+		for _, f := range l.Fields {
+			mark := ""
+			if f.X < 0 || f.Y < 0 || f.X+f.W > l.Wd || f.Y+f.H > l.Ht {
+				mark = " OFF"
+				off++
+			}
+			fmt.Printf("  %-10s x=%-4d y=%-4d w=%-4d h=%-4d (%s,%s %s×%sin) %q%s\n",
+				f.Kind, f.X, f.Y, f.W, f.H, in(f.X), in(f.Y), in(f.W), in(f.H), f.Text, mark)
+		}
+	}
+
+	if *pdfOut != "" {
+		if err := draw(labels, float64(*dpi), *pdfOut); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if off > 0 {
+		fmt.Println(off, "fields off the label")
+		os.Exit(1)
+	}
+}
+
+// parse reads the formats and fields of zpl. It knows the commands
+// Barcoder writes and skips the rest.
+func parse(zpl string) ([]zplLabel, error) {
+	labels := []zplLabel{}
+	var l *zplLabel
+	f := field{}
+	module, fontHt, hex := 2, 0, false
+	for _, c := range strings.Split(zpl, "^")[1:] {
+		c = strings.TrimRight(c, "\r\n")
+		if len(c) < 2 {
+			continue
+		}
+		cmd, arg := c[:2], c[2:]
+		if cmd == "XA" {
+			labels = append(labels, zplLabel{Qt: 1})
+			l = &labels[len(labels)-1]
+			continue
+		}
+		if l == nil {
+			return nil, errors.New("^" + c + " before ^XA")
+		}
+		args := strings.Split(arg, ",")
+		num := func(i int) int {
+			if i >= len(args) {
+				return 0
+			}
+			n, _ := strconv.Atoi(args[i])
+			return n
+		}
+		switch cmd {
+		case "PW":
+			l.Wd = num(0)
+		case "LL":
+			l.Ht = num(0)
+		case "PQ":
+			l.Qt = num(0)
+		case "FO":
+			f = field{X: num(0), Y: num(1)}
+			hex = false
+		case "A0":
+			f.Kind = "text"
+			fontHt = num(1)
+			f.H = fontHt
+		case "FB":
+			f.W = num(0)
+			f.H = fontHt * num(1)
+			if len(args) > 3 {
+				f.Align = args[3]
+			}
+		case "BY":
+			module = num(0)
+		case "BC":
+			f.Kind, f.H = "code128", num(1)
+		case "B3":
+			f.Kind, f.H = "code39", num(2)
+		case "BE":
+			f.Kind, f.H = "ean13", num(1)
+		case "BU":
+			f.Kind, f.H = "upca", num(1)
+		case "BQ":
+			f.Kind = "qr"
+			module = num(2)
+		case "BX":
+			f.Kind = "datamatrix"
+			module = num(1)
+		case "FH":
+			hex = true
+		case "FD":
+			if hex {
+				arg = unhex(arg)
+			}
+			f.Text = arg
+		case "FS":
+			if f.Kind != "text" {
+				if err := f.encode(module); err != nil {
+					return nil, errors.New(f.Kind + " " + f.Text + ": " + err.Error())
+				}
+			}
+			l.Fields = append(l.Fields, f)
+			f = field{}
+		case "XZ":
+			l = nil
+		}
+	}
+	return labels, nil
+}
+
+// unhex undoes ^FH_ escapes.
+func unhex(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '_' && i+2 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// encode makes the barcode f prints, to size it by module dots a
+// module as the printer does.
+func (f *field) encode(module int) error {
+	var err error
+	switch f.Kind {
+	case "code128":
+		f.bc, err = code128.Encode(f.Text)
+	case "code39":
+		f.bc, err = code39.Encode(f.Text, false, false)
+	case "ean13":
+		f.bc, err = ean.Encode(f.Text)
+	case "upca":
+		f.bc, err = ean.Encode("0" + f.Text)
+	case "qr":
+		f.Text = strings.TrimPrefix(f.Text, "MA,")
+		f.bc, err = qr.Encode(f.Text, qr.M, qr.Auto)
+	case "datamatrix":
+		f.bc, err = datamatrix.Encode(f.Text)
+	default:
+		return errors.New("unknown field")
+	}
+	if err != nil {
+		return err
+	}
+	f.W = module * f.bc.Bounds().Dx()
+	if f.Kind == "qr" || f.Kind == "datamatrix" {
+		f.H = module * f.bc.Bounds().Dy()
+	}
+	return nil
+}
+
+// draw puts each label on a page of out, in inches at dpi.
+func draw(labels []zplLabel, dpi float64, out string) error {
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{UnitStr: "in", Size: gofpdf.SizeType{Wd: 4, Ht: 6}})
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	in := func(d int) float64 { return float64(d) / dpi }
+	for _, l := range labels {
+		pdf.AddPageFormat("P", gofpdf.SizeType{Wd: in(l.Wd), Ht: in(l.Ht)})
+		for _, f := range l.Fields {
+			if f.bc != nil {
+				pdfbarcode.Barcode(pdf, pdfbarcode.Register(f.bc), in(f.X), in(f.Y), in(f.W), in(f.H), false)
+				continue
+			}
+			pdf.SetDrawColor(200, 200, 200)
+			pdf.Rect(in(f.X), in(f.Y), in(f.W), in(f.H), "D")
+			pdf.SetFont("Arial", "", in(f.H)*72)
+			pdf.SetXY(in(f.X), in(f.Y))
+			pdf.CellFormat(in(f.W), in(f.H), f.Text, "", 0, f.Align, false, 0, "")
+		}
+	}
+	return pdf.OutputFileAndClose(out)
+}
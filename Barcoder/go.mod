@@ -1,6 +1,7 @@
 module Barcoder
 
 require (
+	github.com/boombuler/barcode v1.0.0
 	github.com/jung-kurt/gofpdf v1.0.2
 	github.com/ruudk/golang-pdf417 v0.0.0-20181029194003-1af4ab5afa58 // indirect
 )
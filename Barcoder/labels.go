@@ -0,0 +1,261 @@
+package barcoder
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/jung-kurt/gofpdf/contrib/barcode"
+)
+
+// label is one label to print. Template, Title, Condition and Lines
+// are optional; see labelTemplate for what each template shows.
+// Symbology is the kind of barcode, Code128 when "". Qt is how many to
+// print; 0 prints one.
+type label struct {
+	Code      string
+	Title     string
+	Condition string
+	Lines     []string
+	Template  string
+	Symbology string
+	Qt        int
+}
+
+// qt is how many of l to print.
+func (l label) qt() int {
+	if l.Qt < 1 {
+		return 1
+	}
+	return l.Qt
+}
+
+// labelTemplate lays out a label. Sizes are inches; font sizes are
+// points. Text never runs past the label; titles are cut with "..."
+// and extra lines that don't fit are left off.
+type labelTemplate struct {
+	Name   string
+	Wd, Ht float64
+	Margin float64
+	// CodeSize is the printed code under the bars, or above them with
+	// CodeAbove; 0 leaves it off.
+	CodeSize  float64
+	CodeAbove bool
+	BarHt     float64
+	// TextSize is for the title, condition and extra lines; 0 leaves
+	// them all off.
+	TextSize float64
+	// TitleLines is how many lines the title may wrap to; 0 leaves it off.
+	TitleLines int
+	// Condition is printed when the label has none; "" prints nothing.
+	Condition string
+}
+
+// Template names.
+const (
+	tmplCode       = "code"
+	tmplFNSKU      = "fnsku"
+	tmplFNSKULarge = "fnsku-large"
+)
+
+// templates are the label layouts a request can name.
+//
+// code is the original plain label: the code in 18pt over a Code128
+// bar on a 3×1.5in page. fnsku fits Amazon's FNSKU label on a
+// 2.625×1in (30-up) label: bars, the FNSKU, one line of title and the
+// condition. fnsku-large is the same on a 3×2in label with two title
+// lines and room for extra lines.
+var templates = map[string]labelTemplate{
+	tmplCode: {
+		Name:      tmplCode,
+		Wd:        3,
+		Ht:        1.5,
+		CodeSize:  18,
+		CodeAbove: true,
+		BarHt:     1,
+	},
+	tmplFNSKU: {
+		Name:       tmplFNSKU,
+		Wd:         2.625,
+		Ht:         1,
+		Margin:     0.06,
+		CodeSize:   8,
+		BarHt:      0.4,
+		TextSize:   7,
+		TitleLines: 1,
+		Condition:  "New",
+	},
+	tmplFNSKULarge: {
+		Name:       tmplFNSKULarge,
+		Wd:         3,
+		Ht:         2,
+		Margin:     0.1,
+		CodeSize:   11,
+		BarHt:      0.75,
+		TextSize:   9,
+		TitleLines: 2,
+		Condition:  "New",
+	},
+}
+
+// findTemplate gets a template by name, with the names known when it
+// isn't one.
+func findTemplate(name string) (labelTemplate, error) {
+	t, ok := templates[name]
+	if !ok {
+		names := []string{}
+		for n := range templates {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return t, errors.New(`unknown template "` + name + `"; use one of ` + strings.Join(names, ", "))
+	}
+	return t, nil
+}
+
+// Kinds of labelElem.
+const (
+	elemBars = "bars"
+	elemText = "text"
+)
+
+// labelElem is one thing drawn on a label, placed from the label's top
+// left corner in inches. Bars have the code in Text and its Symbology.
+type labelElem struct {
+	Kind       string
+	X, Y, W, H float64
+	Text       string
+	Size       float64
+	Align      string
+	Symbology  string
+}
+
+// measureFunc is the width in inches of s in the font at size points.
+type measureFunc func(s string, size float64) float64
+
+// lineHt is the height of a line of text at size points.
+func lineHt(size float64) float64 {
+	return size / 72 * 1.15
+}
+
+// layout places l on t from the top down: the code (when above), the
+// bars, the code (when below), the title, the condition, then extra
+// lines while they fit.
+func (t labelTemplate) layout(l label, measure measureFunc) []labelElem {
+	w := t.Wd - 2*t.Margin
+	y := t.Margin
+	bottom := t.Ht - t.Margin
+	elems := []labelElem{}
+
+	text := func(s string, size float64) bool {
+		h := lineHt(size)
+		if y+h > bottom+1e-9 {
+			return false
+		}
+		elems = append(elems, labelElem{Kind: elemText, X: t.Margin, Y: y, W: w, H: h, Text: s, Size: size, Align: "C"})
+		y += h
+		return true
+	}
+
+	if t.CodeAbove && t.CodeSize > 0 {
+		text(l.Code, t.CodeSize)
+	}
+	bars := labelElem{Kind: elemBars, X: t.Margin, Y: y, W: w, H: t.BarHt, Text: l.Code, Symbology: l.Symbology}
+	if s, _ := findSymbology(l.Symbology); s.Square {
+		bars.W = math.Min(w, t.BarHt)
+		bars.H = bars.W
+		bars.X = t.Margin + (w-bars.W)/2
+	}
+	elems = append(elems, bars)
+	y += t.BarHt
+	if !t.CodeAbove && t.CodeSize > 0 {
+		text(l.Code, t.CodeSize)
+	}
+	if t.TextSize == 0 {
+		return elems
+	}
+
+	if t.TitleLines > 0 && l.Title != "" {
+		for _, ln := range fitLines(l.Title, w, t.TextSize, t.TitleLines, measure) {
+			text(ln, t.TextSize)
+		}
+	}
+	cond := l.Condition
+	if cond == "" {
+		cond = t.Condition
+	}
+	if cond != "" {
+		text(cond, t.TextSize)
+	}
+	for _, ln := range l.Lines {
+		if !text(cut(ln, w, t.TextSize, measure), t.TextSize) {
+			break
+		}
+	}
+	return elems
+}
+
+// fitLines wraps s to width w over at most n lines, cutting the last
+// line with "..." when s doesn't fit.
+func fitLines(s string, w, size float64, n int, measure measureFunc) []string {
+	words := strings.Fields(s)
+	lines := []string{}
+	for len(words) > 0 && len(lines) < n {
+		if len(lines) == n-1 {
+			return append(lines, cut(strings.Join(words, " "), w, size, measure))
+		}
+		k := 1
+		for k < len(words) && measure(strings.Join(words[:k+1], " "), size) <= w {
+			k++
+		}
+		lines = append(lines, cut(strings.Join(words[:k], " "), w, size, measure))
+		words = words[k:]
+	}
+	return lines
+}
+
+// cut shortens s with "..." until it fits width w.
+func cut(s string, w, size float64, measure measureFunc) string {
+	if measure(s, size) <= w {
+		return s
+	}
+	r := []rune(s)
+	for len(r) > 0 && measure(string(r)+"...", size) > w {
+		r = r[:len(r)-1]
+	}
+	return strings.TrimRight(string(r), " ") + "..."
+}
+
+// drawPDF draws elems at x, y on the current pdf page.
+func drawPDF(pdf *gofpdf.Fpdf, x, y float64, elems []labelElem) {
+	for _, el := range elems {
+		switch el.Kind {
+		case elemBars:
+			s, err := findSymbology(el.Symbology)
+			if err != nil {
+				pdf.SetError(err)
+				return
+			}
+			bc, err := s.encode(el.Text)
+			if err != nil {
+				pdf.SetError(errors.New(el.Text + ": " + err.Error()))
+				return
+			}
+			barcode.Barcode(pdf, barcode.Register(bc), x+el.X, y+el.Y, el.W, el.H, false)
+		case elemText:
+			pdf.SetFont("Arial", "", el.Size)
+			pdf.SetXY(x+el.X, y+el.Y)
+			pdf.CellFormat(el.W, el.H, el.Text, "", 0, el.Align, false, 0, "")
+		}
+	}
+}
+
+// pdfMeasure measures text in the Arial pdf uses.
+func pdfMeasure(pdf *gofpdf.Fpdf) measureFunc {
+	return func(s string, size float64) float64 {
+		pdf.SetFont("Arial", "", size)
+		return pdf.GetStringWidth(s)
+	}
+}
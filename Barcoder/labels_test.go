@@ -0,0 +1,108 @@
+package barcoder
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// monoMeasure is a fixed width font: 0.1in a character at 10pt.
+func monoMeasure(s string, size float64) float64 {
+	return float64(utf8.RuneCountInString(s)) * size / 100
+}
+
+func TestFitLines(t *testing.T) {
+	long := "Blue Widget Large Size Pack Of Twelve"
+	tests := []struct {
+		s    string
+		n    int
+		want []string
+	}{
+		{"", 2, []string{}},
+		{"Blue Widget", 1, []string{"Blue Widget"}},
+		{"  Blue   Widget ", 2, []string{"Blue Widget"}},
+		{long, 1, []string{"Blue Widget Large..."}},
+		{long, 2, []string{"Blue Widget Large", "Size Pack Of Twelve"}},
+		{long, 3, []string{"Blue Widget Large", "Size Pack Of Twelve"}},
+		{"ABCDEFGHIJKLMNOPQRSTUVWXYZ", 2, []string{"ABCDEFGHIJKLMNOPQ..."}},
+		{long, 0, []string{}},
+	}
+	for _, tt := range tests {
+		got := fitLines(tt.s, 2, 10, tt.n, monoMeasure)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("fitLines(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+		for _, ln := range got {
+			if monoMeasure(ln, 10) > 2 {
+				t.Errorf("fitLines(%q, %d): %q is wider than the label", tt.s, tt.n, ln)
+			}
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	// Room for the code under the bars and four lines of text.
+	tmpl := labelTemplate{Name: "test", Wd: 2, Ht: 1.3, CodeSize: 10, BarHt: 0.5, TextSize: 10, TitleLines: 2, Condition: "New"}
+	tests := []struct {
+		name string
+		tmpl labelTemplate
+		l    label
+		// want lists the elements top down, bars as [code].
+		want []string
+	}{
+		{
+			name: "no title",
+			tmpl: tmpl,
+			l:    label{Code: "X1"},
+			want: []string{"[X1]", "X1", "New"},
+		},
+		{
+			name: "title",
+			tmpl: tmpl,
+			l:    label{Code: "X1", Title: "Blue Widget"},
+			want: []string{"[X1]", "X1", "Blue Widget", "New"},
+		},
+		{
+			name: "title wraps",
+			tmpl: tmpl,
+			l:    label{Code: "X1", Title: "Blue Widget Large Size Pack Of Twelve"},
+			want: []string{"[X1]", "X1", "Blue Widget Large", "Size Pack Of Twelve", "New"},
+		},
+		{
+			name: "lines that don't fit left off",
+			tmpl: tmpl,
+			l:    label{Code: "X1", Condition: "Used", Lines: []string{"Bin A1", "Bin B2", "Bin C3", "Bin D4"}},
+			want: []string{"[X1]", "X1", "Used", "Bin A1", "Bin B2", "Bin C3"},
+		},
+		{
+			name: "long line cut",
+			tmpl: tmpl,
+			l:    label{Code: "X1", Lines: []string{"Aisle 12 Shelf 4 Bin 7 Back"}},
+			want: []string{"[X1]", "X1", "New", "Aisle 12 Shelf 4..."},
+		},
+		{
+			name: "code above",
+			tmpl: templates[tmplCode],
+			l:    label{Code: "X1", Title: "Blue Widget"},
+			want: []string{"X1", "[X1]"},
+		},
+	}
+	for _, tt := range tests {
+		elems := tt.tmpl.layout(tt.l, monoMeasure)
+		got := []string{}
+		for _, el := range elems {
+			if el.Kind == elemBars {
+				got = append(got, "["+el.Text+"]")
+			} else {
+				got = append(got, el.Text)
+			}
+			if el.X < tt.tmpl.Margin-1e-9 || el.Y < tt.tmpl.Margin-1e-9 ||
+				el.X+el.W > tt.tmpl.Wd-tt.tmpl.Margin+1e-9 || el.Y+el.H > tt.tmpl.Ht-tt.tmpl.Margin+1e-9 {
+				t.Errorf("%s: %+v runs off the label", tt.name, el)
+			}
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: layout = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
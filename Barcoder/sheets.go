@@ -0,0 +1,158 @@
+package barcoder
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+const mm = 1 / 25.4
+
+// printerMargin is the edge of the page most printers can't reach, in
+// inches.
+const printerMargin = 0.1
+
+// sheetLayout tiles labels onto a Letter or A4 sheet. Sizes are inches.
+type sheetLayout struct {
+	Name           string
+	PageWd, PageHt float64
+	Cols, Rows     int
+	LabelWd        float64
+	LabelHt        float64
+	Top, Left      float64
+	ColGap, RowGap float64
+}
+
+// sheets are the label sheets a request can name.
+var sheets = map[string]sheetLayout{
+	// Avery 5160, 30-up 1×2-5/8in on Letter.
+	"avery-5160": {
+		Name: "avery-5160", PageWd: 8.5, PageHt: 11,
+		Cols: 3, Rows: 10, LabelWd: 2.625, LabelHt: 1,
+		Top: 0.5, Left: 0.1875, ColGap: 0.125,
+	},
+	// 44-up 48.5×25.4mm on A4.
+	"a4-44up": {
+		Name: "a4-44up", PageWd: 210 * mm, PageHt: 297 * mm,
+		Cols: 4, Rows: 11, LabelWd: 48.5 * mm, LabelHt: 25.4 * mm,
+		Top: 8.8 * mm, Left: 8 * mm,
+	},
+	// 24-up 63.5×33.9mm on A4, like Avery L7159.
+	"a4-24up": {
+		Name: "a4-24up", PageWd: 210 * mm, PageHt: 297 * mm,
+		Cols: 3, Rows: 8, LabelWd: 63.5 * mm, LabelHt: 33.9 * mm,
+		Top: 12.9 * mm, Left: 7.25 * mm, ColGap: 2.5 * mm,
+	},
+	// 21-up 63.5×38.1mm on A4, like Avery L7160.
+	"a4-21up": {
+		Name: "a4-21up", PageWd: 210 * mm, PageHt: 297 * mm,
+		Cols: 3, Rows: 7, LabelWd: 63.5 * mm, LabelHt: 38.1 * mm,
+		Top: 15.15 * mm, Left: 7.25 * mm, ColGap: 2.5 * mm,
+	},
+}
+
+// sheetRequest picks a sheet layout and adjusts it. The margins and
+// gaps are inches and replace the layout's when set. Start skips that
+// many labels at the top of the first sheet, for a sheet that has been
+// partly used.
+type sheetRequest struct {
+	Layout string
+	Top    *float64
+	Left   *float64
+	ColGap *float64
+	RowGap *float64
+	Start  int
+}
+
+// layout builds the sheet layout for the request and checks the labels
+// fit the printable area of the page.
+func (sr *sheetRequest) layout() (sheetLayout, error) {
+	sl, ok := sheets[sr.Layout]
+	if !ok {
+		names := []string{}
+		for n := range sheets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return sl, errors.New(`unknown sheet "` + sr.Layout + `"; use one of ` + strings.Join(names, ", "))
+	}
+	for _, o := range []struct {
+		v   *float64
+		dst *float64
+	}{
+		{sr.Top, &sl.Top},
+		{sr.Left, &sl.Left},
+		{sr.ColGap, &sl.ColGap},
+		{sr.RowGap, &sl.RowGap},
+	} {
+		if o.v != nil {
+			*o.dst = *o.v
+		}
+	}
+
+	probs := []string{}
+	if sl.Top < 0 || sl.Left < 0 || sl.ColGap < 0 || sl.RowGap < 0 {
+		probs = append(probs, "margins and gaps must be 0 or more")
+	}
+	if sr.Start < 0 || sr.Start >= sl.Cols*sl.Rows {
+		probs = append(probs, "start must be from 0 to "+strconv.Itoa(sl.Cols*sl.Rows-1))
+	}
+	right := sl.Left + float64(sl.Cols)*sl.LabelWd + float64(sl.Cols-1)*sl.ColGap
+	bottom := sl.Top + float64(sl.Rows)*sl.LabelHt + float64(sl.Rows-1)*sl.RowGap
+	if sl.Left < printerMargin || sl.Top < printerMargin ||
+		right > sl.PageWd-printerMargin+1e-6 || bottom > sl.PageHt-printerMargin+1e-6 {
+		probs = append(probs, "labels run outside the printable area, "+ftoa(printerMargin)+"in in from each edge")
+	}
+	if len(probs) > 0 {
+		return sl, errors.New("sheet " + sl.Name + ": " + strings.Join(probs, "; "))
+	}
+	return sl, nil
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// cell is the top left corner of label position i on its sheet.
+func (sl sheetLayout) cell(i int) (x, y float64) {
+	i %= sl.Cols * sl.Rows
+	col, row := i%sl.Cols, i/sl.Cols
+	return sl.Left + float64(col)*(sl.LabelWd+sl.ColGap), sl.Top + float64(row)*(sl.LabelHt+sl.RowGap)
+}
+
+// fit resizes t to a w×h label. Margins, bars and fonts shrink with the
+// label when it is smaller than the template, and never grow.
+func (t labelTemplate) fit(w, h float64) labelTemplate {
+	k := math.Min(1, math.Min(w/t.Wd, h/t.Ht))
+	t.Wd, t.Ht = w, h
+	t.Margin *= k
+	t.BarHt *= k
+	t.CodeSize *= k
+	t.TextSize *= k
+	return t
+}
+
+// tileSheet draws labels onto sl sheets, starting at position start of
+// the first sheet.
+func tileSheet(pdf *gofpdf.Fpdf, sl sheetLayout, start int, labels []label) error {
+	size := gofpdf.SizeType{Wd: sl.PageWd, Ht: sl.PageHt}
+	per := sl.Cols * sl.Rows
+	for n, l := range labels {
+		pos := start + n
+		if n == 0 || pos%per == 0 {
+			pdf.AddPageFormat("P", size)
+		}
+		t, err := findTemplate(l.Template)
+		if err != nil {
+			return errors.New(l.Code + ": " + err.Error())
+		}
+		t = t.fit(sl.LabelWd, sl.LabelHt)
+		x, y := sl.cell(pos)
+		drawPDF(pdf, x, y, t.layout(l, pdfMeasure(pdf)))
+	}
+	return nil
+}
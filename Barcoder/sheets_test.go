@@ -0,0 +1,50 @@
+package barcoder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSheetRequestLayout(t *testing.T) {
+	in := func(f float64) *float64 { return &f }
+	tests := []struct {
+		name string
+		sr   sheetRequest
+		err  string
+	}{
+		{name: "avery", sr: sheetRequest{Layout: "avery-5160"}},
+		{name: "last start", sr: sheetRequest{Layout: "avery-5160", Start: 29}},
+		{name: "margin moved", sr: sheetRequest{Layout: "avery-5160", Top: in(0.4), Left: in(0.15)}},
+		{name: "unknown", sr: sheetRequest{Layout: "avery-9999"}, err: `unknown sheet "avery-9999"; use one of a4-21up, a4-24up, a4-44up, avery-5160`},
+		{name: "start past sheet", sr: sheetRequest{Layout: "avery-5160", Start: 30}, err: "start must be from 0 to 29"},
+		{name: "negative start", sr: sheetRequest{Layout: "avery-5160", Start: -1}, err: "start must be from 0 to 29"},
+		{name: "negative gap", sr: sheetRequest{Layout: "avery-5160", RowGap: in(-0.1)}, err: "margins and gaps must be 0 or more"},
+		{name: "top in printer margin", sr: sheetRequest{Layout: "avery-5160", Top: in(0.05)}, err: "outside the printable area"},
+		{name: "gaps push off page", sr: sheetRequest{Layout: "avery-5160", ColGap: in(0.2)}, err: "outside the printable area"},
+	}
+	for _, tt := range tests {
+		sl, err := tt.sr.layout()
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				continue
+			}
+			if tt.sr.Top != nil && sl.Top != *tt.sr.Top || tt.sr.Left != nil && sl.Left != *tt.sr.Left {
+				t.Errorf("%s: margins %v, %v not taken from the request", tt.name, sl.Top, sl.Left)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestSheetsFit(t *testing.T) {
+	for name := range sheets {
+		sr := sheetRequest{Layout: name}
+		if _, err := sr.layout(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
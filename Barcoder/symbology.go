@@ -0,0 +1,173 @@
+package barcoder
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/code39"
+	"github.com/boombuler/barcode/datamatrix"
+	"github.com/boombuler/barcode/ean"
+	"github.com/boombuler/barcode/qr"
+)
+
+// Symbology names. Labels that don't name one use Code128.
+const (
+	symCode128    = "code128"
+	symCode39     = "code39"
+	symEAN13      = "ean13"
+	symUPCA       = "upca"
+	symQR         = "qr"
+	symDataMatrix = "datamatrix"
+)
+
+// code39Chars are what Code39 can print without full ASCII mode.
+const code39Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ-. $/+%"
+
+// symbology encodes codes as one kind of barcode.
+type symbology struct {
+	Name string
+	// Square codes are drawn as a square as tall as the template's bars,
+	// in the middle of the label.
+	Square bool
+	encode func(code string) (barcode.Barcode, error)
+}
+
+var symbologies = map[string]symbology{
+	symCode128: {
+		Name: symCode128,
+		encode: func(code string) (barcode.Barcode, error) {
+			for _, r := range code {
+				if r > 127 {
+					return nil, errors.New("Code128 takes ASCII only, got " + strconv.QuoteRune(r))
+				}
+			}
+			return code128.Encode(code)
+		},
+	},
+	symCode39: {
+		Name: symCode39,
+		encode: func(code string) (barcode.Barcode, error) {
+			for _, r := range code {
+				if !strings.ContainsRune(code39Chars, r) {
+					return nil, errors.New("Code39 takes 0-9, A-Z and -. $/+% only, got " + strconv.QuoteRune(r))
+				}
+			}
+			return code39.Encode(code, false, false)
+		},
+	},
+	symEAN13: {
+		Name: symEAN13,
+		encode: func(code string) (barcode.Barcode, error) {
+			if err := checkDigit(code, 13); err != nil {
+				return nil, errors.New("EAN-13 " + err.Error())
+			}
+			return ean.Encode(code)
+		},
+	},
+	symUPCA: {
+		Name: symUPCA,
+		encode: func(code string) (barcode.Barcode, error) {
+			if err := checkDigit(code, 12); err != nil {
+				return nil, errors.New("UPC-A " + err.Error())
+			}
+			// A UPC-A is an EAN-13 with a leading 0.
+			return ean.Encode("0" + code)
+		},
+	},
+	symQR: {
+		Name:   symQR,
+		Square: true,
+		encode: func(code string) (barcode.Barcode, error) {
+			return qr.Encode(code, qr.M, qr.Auto)
+		},
+	},
+	symDataMatrix: {
+		Name:   symDataMatrix,
+		Square: true,
+		encode: func(code string) (barcode.Barcode, error) {
+			return datamatrix.Encode(code)
+		},
+	},
+}
+
+// findSymbology gets a symbology by name, Code128 for "".
+func findSymbology(name string) (symbology, error) {
+	if name == "" {
+		name = symCode128
+	}
+	s, ok := symbologies[name]
+	if !ok {
+		names := []string{}
+		for n := range symbologies {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return s, errors.New(`unknown symbology "` + name + `"; use one of ` + strings.Join(names, ", "))
+	}
+	return s, nil
+}
+
+// checkDigit checks code is n digits, the last being the GS1 check
+// digit of the rest.
+func checkDigit(code string, n int) error {
+	if len(code) != n {
+		return errors.New("must be " + strconv.Itoa(n) + " digits, got " + strconv.Itoa(len(code)))
+	}
+	sum := 0
+	for i, r := range code {
+		if r < '0' || r > '9' {
+			return errors.New("must be digits only, got " + strconv.QuoteRune(r))
+		}
+		// From the right, data digits are weighted 3, 1, 3...
+		if i < n-1 {
+			d := int(r - '0')
+			if (n-1-i)%2 == 1 {
+				d *= 3
+			}
+			sum += d
+		}
+	}
+	if want := (10 - sum%10) % 10; int(code[n-1]-'0') != want {
+		return errors.New("check digit is " + code[n-1:] + ", should be " + strconv.Itoa(want))
+	}
+	return nil
+}
+
+// codeError is why a code can't be printed.
+type codeError struct {
+	Code      string
+	Symbology string
+	Error     string
+}
+
+// checkCodes encodes every code in groups with its symbology, once for
+// each code and symbology, and lists those that fail.
+func checkCodes(groups []labelGroup) []codeError {
+	errs := []codeError{}
+	seen := map[[2]string]bool{}
+	for _, g := range groups {
+		for _, l := range g.Labels {
+			k := [2]string{l.Code, l.Symbology}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+
+			s, err := findSymbology(l.Symbology)
+			if err == nil && l.Code == "" {
+				err = errors.New("no code")
+			}
+			if err == nil {
+				_, err = s.encode(l.Code)
+			}
+			if err != nil {
+				errs = append(errs, codeError{l.Code, l.Symbology, err.Error()})
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,45 @@
+package barcoder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckDigit(t *testing.T) {
+	tests := []struct {
+		code string
+		n    int
+		err  string
+	}{
+		{"4006381333931", 13, ""},
+		{"5901234123457", 13, ""},
+		{"036000291452", 12, ""},
+		{"012345678905", 12, ""},
+		{"4006381333932", 13, "check digit is 2, should be 1"},
+		{"036000291453", 12, "check digit is 3, should be 2"},
+		{"03600029145", 12, "must be 12 digits, got 11"},
+		{"036000291452", 13, "must be 13 digits, got 12"},
+		{"03600029145X", 12, "must be digits only"},
+		{"", 12, "must be 12 digits, got 0"},
+	}
+	for _, tt := range tests {
+		err := checkDigit(tt.code, tt.n)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("checkDigit(%q, %d) = %v, want nil", tt.code, tt.n, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("checkDigit(%q, %d) = %v, want %q", tt.code, tt.n, err, tt.err)
+		}
+	}
+}
+
+func TestLayoutSquare(t *testing.T) {
+	tmpl := labelTemplate{Wd: 2, Ht: 1, BarHt: 0.5}
+	elems := tmpl.layout(label{Code: "X1", Symbology: symQR}, monoMeasure)
+	if len(elems) != 1 {
+		t.Fatalf("layout = %+v, want just the code", elems)
+	}
+	if el := elems[0]; el.W != 0.5 || el.H != 0.5 || el.X != 0.75 {
+		t.Errorf("QR at x %v, %v×%v; want a 0.5in square at 0.75", el.X, el.W, el.H)
+	}
+}
@@ -0,0 +1,174 @@
+package barcoder
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Output formats set by publishRequest Format.
+const (
+	formatPDF = "pdf"
+	formatZPL = "zpl"
+)
+
+// zplDPIs are the Zebra print head densities ZPL can be made for.
+var zplDPIs = map[int]bool{203: true, 300: true}
+
+// zplEscape escapes what ZPL would read as commands, for use with ^FH_.
+var zplEscape = strings.NewReplacer("_", "_5F", "^", "_5E", "~", "_7E")
+
+// makeZPL writes each group as ZPL II for a Zebra printer at dpi, one
+// ^XA...^XZ format a label with its Qt in ^PQ. A group with a name
+// starts with a separator label the size of its first label.
+//
+// Text is laid out with the pdf's Arial widths, which are wider than
+// Zebra font 0, so lines that fit the pdf also fit the label.
+func makeZPL(groups []labelGroup, dpi int) (string, error) {
+	measure := pdfMeasure(gofpdf.New("P", "in", "Letter", ""))
+	z := zplWriter{dpi: dpi}
+	for _, g := range groups {
+		if g.Name != "" {
+			t, err := findTemplate(g.Labels[0].Template)
+			if err != nil {
+				return "", errors.New(g.Name + ": " + err.Error())
+			}
+			if err := z.separator(t.Wd, t.Ht, g); err != nil {
+				return "", errors.New(g.Name + ": " + err.Error())
+			}
+		}
+		for _, l := range g.Labels {
+			t, err := findTemplate(l.Template)
+			if err != nil {
+				return "", errors.New(l.Code + ": " + err.Error())
+			}
+			if err := z.label(t.Wd, t.Ht, t.layout(l, measure), l.qt()); err != nil {
+				return "", errors.New(l.Code + ": " + err.Error())
+			}
+		}
+	}
+	return z.String(), nil
+}
+
+// zplWriter builds ZPL at dpi, sizes given in inches.
+type zplWriter struct {
+	strings.Builder
+	dpi int
+}
+
+// dots is in inches as print head dots.
+func (z *zplWriter) dots(in float64) int {
+	return int(math.Round(in * float64(z.dpi)))
+}
+
+func (z *zplWriter) cmd(c string, args ...int) {
+	z.WriteString(c)
+	for i, a := range args {
+		if i > 0 {
+			z.WriteByte(',')
+		}
+		z.WriteString(strconv.Itoa(a))
+	}
+}
+
+// label writes a w×h label of elems, printed qt times.
+func (z *zplWriter) label(w, h float64, elems []labelElem, qt int) error {
+	z.WriteString("^XA^CI28")
+	z.cmd("^PW", z.dots(w))
+	z.cmd("^LL", z.dots(h))
+	z.WriteByte('\n')
+	for _, el := range elems {
+		switch el.Kind {
+		case elemBars:
+			if err := z.bars(el); err != nil {
+				return err
+			}
+		case elemText:
+			z.text(el)
+		}
+	}
+	z.cmd("^PQ", qt)
+	z.WriteString("^XZ\n")
+	return nil
+}
+
+// text writes el in font 0 in a one line field block as wide as el,
+// in the middle of el's height like pdf CellFormat.
+func (z *zplWriter) text(el labelElem) {
+	ht := el.Size / 72
+	align := "L"
+	if el.Align == "C" || el.Align == "R" {
+		align = el.Align
+	}
+	z.cmd("^FO", z.dots(el.X), z.dots(el.Y+(el.H-ht)/2))
+	z.cmd("^A0N,", z.dots(ht), 0)
+	z.cmd("^FB", z.dots(el.W), 1, 0)
+	z.WriteString("," + align + ",0^FH_^FD" + zplEscape.Replace(el.Text) + "^FS\n")
+}
+
+// bars writes el as its symbology's ZPL barcode. Zebra prints whole
+// dots a module, so the code is as wide as fits in el and centered.
+func (z *zplWriter) bars(el labelElem) error {
+	s, err := findSymbology(el.Symbology)
+	if err != nil {
+		return err
+	}
+	bc, err := s.encode(el.Text)
+	if err != nil {
+		return err
+	}
+	modules := bc.Bounds().Dx()
+	w, h := z.dots(el.W), z.dots(el.H)
+	module := w / modules
+	if module < 1 {
+		module = 1
+	}
+	if s.Square && module > 10 {
+		// ^BQ magnification stops at 10.
+		module = 10
+	}
+	x := z.dots(el.X) + (w-module*modules)/2
+	if x < z.dots(el.X) {
+		x = z.dots(el.X)
+	}
+	y := z.dots(el.Y)
+	if s.Square {
+		y += (h - module*modules) / 2
+	}
+
+	z.cmd("^FO", x, y)
+	z.cmd("^BY", module)
+	code, ht := el.Text, strconv.Itoa(h)
+	switch s.Name {
+	case symCode128:
+		z.WriteString("^BCN," + ht + ",N,N,N,A")
+	case symCode39:
+		z.WriteString("^B3N,N," + ht + ",N,N")
+	case symEAN13:
+		// The printer adds the check digit.
+		z.WriteString("^BEN," + ht + ",N,N")
+		code = code[:12]
+	case symUPCA:
+		z.WriteString("^BUN," + ht + ",N,N,Y")
+		code = code[:11]
+	case symQR:
+		z.cmd("^BQN,", 2, module)
+		code = "MA," + code
+	case symDataMatrix:
+		z.cmd("^BXN,", module, 200)
+	}
+	z.WriteString("^FH_^FD" + zplEscape.Replace(code) + "^FS\n")
+	return nil
+}
+
+// separator writes a w×h label naming group g, its SKUs and label count.
+func (z *zplWriter) separator(w, h float64, g labelGroup) error {
+	size := separatorSize(h)
+	return z.label(w, h, []labelElem{
+		{Kind: elemText, Y: h/2 - lineHt(size), W: w, H: lineHt(size), Text: g.Name, Size: size, Align: "C"},
+		{Kind: elemText, Y: h/2 + lineHt(size)/4, W: w, H: lineHt(size / 2), Text: g.summary(), Size: size / 2, Align: "C"},
+	}, 1)
+}
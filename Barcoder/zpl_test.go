@@ -0,0 +1,49 @@
+package barcoder
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMakeZPLOrigins(t *testing.T) {
+	groups := []labelGroup{{
+		Name:   "Acme",
+		Labels: []label{{Code: "X00ABC1234", Template: tmplFNSKULarge, Qt: 3}},
+	}}
+	// The separator's two lines, then the bars, the code and the
+	// condition of the 3×2in fnsku-large label. The 134 module Code128
+	// is centered in the 2.8in between the margins.
+	tests := []struct {
+		dpi     int
+		size    string
+		origins []string
+	}{
+		{203, "^PW609^LL406", []string{"0,106", "0,232", "36,20", "20,175", "20,210"}},
+		{300, "^PW900^LL600", []string{"0,157", "0,343", "48,30", "30,258", "30,311"}},
+	}
+	fo := regexp.MustCompile(`\^FO(\d+,\d+)`)
+	for _, tt := range tests {
+		z, err := makeZPL(groups, tt.dpi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(z, tt.size); n != 2 {
+			t.Errorf("%d dpi: %d labels sized %s, want 2", tt.dpi, n, tt.size)
+		}
+		got := []string{}
+		for _, m := range fo.FindAllStringSubmatch(z, -1) {
+			got = append(got, m[1])
+		}
+		if strings.Join(got, " ") != strings.Join(tt.origins, " ") {
+			t.Errorf("%d dpi: field origins %v, want %v", tt.dpi, got, tt.origins)
+		}
+		if !strings.Contains(z, "^PQ3^XZ") {
+			t.Errorf("%d dpi: no ^PQ3 for the label's Qt in\n%s", tt.dpi, z)
+		}
+	}
+
+	if _, err := makeZPL([]labelGroup{{Labels: []label{{Code: "X", Template: "nope"}}}}, 203); err == nil {
+		t.Error("makeZPL with an unknown template: want error")
+	}
+}
@@ -0,0 +1,137 @@
+package stock
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// What happens to the reports a run used, from delete_source and
+// hard_delete.
+const (
+	consumeKeep    = "keep"
+	consumeArchive = "archive"
+	consumeDelete  = "delete"
+)
+
+// archivedFile is a report moved out of the source by a run, and where
+// it went so it can be put back. The source keeps the record with the
+// file, see reportSource.
+type archivedFile struct {
+	Name string
+	// ID is the file's Drive ID or path before it was moved.
+	ID string
+	// From and To are the folder IDs for Drive and the paths under the
+	// report dir for a dir.
+	From string
+	To   string
+	Run  string
+	Time time.Time
+}
+
+// consumeMode picks what to do with the used reports. delete_source
+// archives them; hard_delete must also be set to delete them.
+func consumeMode(p publishRequest) string {
+	switch {
+	case !p.DeleteSource:
+		return consumeKeep
+	case p.HardDelete:
+		return consumeDelete
+	}
+	return consumeArchive
+}
+
+// consumeSource archives or deletes the files run used from src. Archived
+// files go in a subfolder named for the day of now and are returned.
+func consumeSource(src reportSource, files []reportFile, mode, run string, now time.Time) ([]archivedFile, error) {
+	archived := []archivedFile{}
+	switch mode {
+	case consumeArchive:
+		folder := now.Format("2006-01-02")
+		logP("archiving source files to " + folder + ".")
+		for _, f := range files {
+			a, err := src.archive(f, folder, run)
+			if err != nil {
+				return archived, errors.New(f.Name + `: ` + err.Error())
+			}
+			if a.To == "" {
+				continue
+			}
+			a.Run, a.Time = run, now
+			archived = append(archived, a)
+		}
+	case consumeDelete:
+		logP("deleating source files.")
+		for _, f := range files {
+			if err := src.remove(f); err != nil {
+				return archived, errors.New(f.Name + `: ` + err.Error())
+			}
+		}
+	}
+	return archived, nil
+}
+
+// consume archives or deletes the reports a run used. It is called once
+// the run is saved and its output made, so reports only leave the
+// source for a run that is on record.
+func (e *engine) consume(src reportSource, used []reportFile, resp *apiRespond) error {
+	mode := consumeMode(e.opts)
+	if mode == consumeKeep {
+		return nil
+	}
+
+	archived, err := consumeSource(src, used, mode, resp.RunID, time.Now())
+	resp.Archived = archived
+	return err
+}
+
+// restoreRequest names the run to restore and its report source, as
+// in publishRequest.
+type restoreRequest struct {
+	Run    string `json:"run"`
+	Source string `json:"source"`
+	Dir    string `json:"dir"`
+}
+
+// restoreHandler puts back the reports a run archived, from the record
+// the source keeps with them.
+func restoreHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errLog.Println("iouitl.ReadAll:", err)
+		http.Error(w, "Error reading request", http.StatusBadRequest)
+		return
+	}
+	rr := restoreRequest{}
+	if err := json.Unmarshal(req, &rr); err != nil || rr.Run == "" {
+		errLog.Println("json.Unmarshal:", err)
+		http.Error(w, "Error parsing request; run is required", http.StatusBadRequest)
+		return
+	}
+	if _, err := time.Parse(runIDLayout, rr.Run); err != nil {
+		errLog.Println("time.Parse:", err)
+		http.Error(w, "Error bad run "+rr.Run, http.StatusBadRequest)
+		return
+	}
+
+	src, err := newSource(publishRequest{Source: rr.Source, Dir: rr.Dir}, nil)
+	if err != nil {
+		errLog.Println("newSource:", err)
+		http.Error(w, "Error opening report source", http.StatusBadRequest)
+		return
+	}
+	restored, err := src.restore(rr.Run)
+	if err != nil {
+		errLog.Println("restore:", err)
+		http.Error(w, "Error restoring source", http.StatusInternalServerError)
+		return
+	}
+	if len(restored) == 0 {
+		http.Error(w, "Error finding archived files of run "+rr.Run, http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(&restored)
+}
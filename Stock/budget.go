@@ -0,0 +1,154 @@
+package stock
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// Budget goals set by budget_goal.
+const (
+	// goalProfit spends the budget on the best profit per dollar first.
+	goalProfit = "profit"
+	// goalCover tops up the SKU with the fewest days of cover first.
+	goalCover = "cover"
+
+	rejOverBudget = "over_budget"
+
+	// minQt is the fewest units getSugQt will suggest.
+	minQt = 2
+)
+
+// budgetResult is how the budget was spent.
+type budgetResult struct {
+	Budget float64
+	Goal   string
+	Used   float64
+	Left   float64
+	// ScaledDown were cut to fit; Dropped could not get minQt units.
+	ScaledDown []string
+	Dropped    []string
+}
+
+// checkBudget fills in and checks the budget fields of p.
+func checkBudget(p *publishRequest) error {
+	if p.Budget < 0 {
+		return errors.New("checkBudget: budget must be 0 or more, got " + ftoa(p.Budget))
+	}
+	switch p.BudgetGoal {
+	case "":
+		p.BudgetGoal = goalProfit
+	case goalProfit, goalCover:
+	default:
+		return errors.New(`checkBudget: budget_goal must be "` + goalProfit + `" or "` + goalCover + `", got "` + p.BudgetGoal + `"`)
+	}
+	return nil
+}
+
+// allocateBudget picks quantities, up to each SugQt, that cost no more
+// than the request budget in total. It returns nil when there is no
+// budget.
+//
+// For the profit goal SKUs are filled whole in order of estimated profit
+// per dollar of cost; SKUs with no estimated profit get none. For the
+// cover goal units go one at a time to the
+// SKU with the fewest days of cover at Amazon. Either way a SKU gets
+// minQt units or none. SKUs with no cost take no budget and are kept.
+func (e *engine) allocateBudget(filz *fbaStockFiles) *budgetResult {
+	if e.opts.Budget <= 0 {
+		return nil
+	}
+	br := &budgetResult{Budget: e.opts.Budget, Goal: e.opts.BudgetGoal}
+
+	skus := sortedKeys(filz.CAData)
+	give := make(map[string]int, len(skus))
+	left := e.opts.Budget
+	paid := []string{}
+	for _, sku := range skus {
+		if filz.CAData[sku].Cost <= 0 {
+			give[sku] = filz.CAData[sku].SugQt
+			continue
+		}
+		paid = append(paid, sku)
+	}
+
+	switch e.opts.BudgetGoal {
+	case goalCover:
+		for {
+			best, bestDays := "", math.Inf(1)
+			for _, sku := range paid {
+				t := filz.CAData[sku]
+				step := 1
+				if give[sku] == 0 {
+					step = minQt
+				}
+				if give[sku]+step > t.SugQt || float64(step)*t.Cost > left {
+					continue
+				}
+				days := math.Inf(1)
+				if daily := filz.dailySales(sku); daily > 0 {
+					days = float64(t.FBAQt+give[sku]) / daily
+				}
+				if best == "" || days < bestDays {
+					best, bestDays = sku, days
+				}
+			}
+			if best == "" {
+				break
+			}
+			step := 1
+			if give[best] == 0 {
+				step = minQt
+			}
+			give[best] += step
+			left -= float64(step) * filz.CAData[best].Cost
+		}
+	default:
+		sort.SliceStable(paid, func(i, j int) bool {
+			a, b := filz.CAData[paid[i]], filz.CAData[paid[j]]
+			return a.EstProf/a.Cost > b.EstProf/b.Cost
+		})
+		for _, sku := range paid {
+			t := filz.CAData[sku]
+			if t.EstProf <= 0 {
+				continue
+			}
+			qt := int(math.Floor(left/t.Cost + 1e-9))
+			if qt > t.SugQt {
+				qt = t.SugQt
+			}
+			if qt < minQt {
+				continue
+			}
+			give[sku] = qt
+			left -= float64(qt) * t.Cost
+		}
+	}
+
+	for _, sku := range skus {
+		t := filz.CAData[sku]
+		qt := give[sku]
+		if qt == t.SugQt {
+			continue
+		}
+		nums := map[string]float64{
+			"SugQt":   float64(t.SugQt),
+			"Cost":    t.Cost,
+			"EstProf": t.EstProf,
+			"Budget":  e.opts.Budget,
+		}
+		if qt < minQt {
+			br.Dropped = append(br.Dropped, sku)
+			filz.reject(sku, rejOverBudget, nums)
+			continue
+		}
+		br.ScaledDown = append(br.ScaledDown, sku)
+		t.Cuts = append(t.Cuts, qtCut{rejOverBudget, t.SugQt, nums})
+		t.SugQt = qt
+		filz.CAData[sku] = t
+	}
+
+	br.Left = math.Round(left*100) / 100
+	br.Used = math.Round((e.opts.Budget-left)*100) / 100
+	return br
+}
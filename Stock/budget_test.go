@@ -0,0 +1,133 @@
+package stock
+
+import "testing"
+
+func TestAllocateBudget(t *testing.T) {
+	sku := func(cost, prof float64, sugQt, fbaQt, sold int) topSellerH {
+		t := topSellerH{top: top{QtySold: sold}, SugQt: sugQt, FBAQt: fbaQt, EstProf: prof}
+		t.Cost = cost
+		return t
+	}
+	// capped is t after the capacity cap took it down from 8.
+	capped := func(t topSellerH) topSellerH {
+		t.Cuts = qtCuts{{Reason: rejOverCapacity, FromQt: 8}}
+		return t
+	}
+	tests := []struct {
+		name    string
+		goal    string
+		budget  float64
+		skus    map[string]topSellerH
+		want    map[string]int
+		dropped []string
+		left    float64
+	}{
+		{
+			name:   "no budget",
+			goal:   goalProfit,
+			skus:   map[string]topSellerH{"A": sku(10, 5, 5, 0, 30)},
+			want:   map[string]int{"A": 5},
+			left:   0,
+			budget: 0,
+		},
+		{
+			name:   "profit fits all",
+			goal:   goalProfit,
+			budget: 200,
+			skus:   map[string]topSellerH{"A": sku(10, 5, 5, 0, 30), "B": sku(10, 2, 10, 0, 30)},
+			want:   map[string]int{"A": 5, "B": 10},
+			left:   50,
+		},
+		{
+			name:   "profit best per dollar first",
+			goal:   goalProfit,
+			budget: 100,
+			skus:   map[string]topSellerH{"A": sku(10, 5, 5, 0, 30), "B": sku(10, 2, 10, 0, 30)},
+			want:   map[string]int{"A": 5, "B": 5},
+			left:   0,
+		},
+		{
+			name:    "profit skips no profit",
+			goal:    goalProfit,
+			budget:  100,
+			skus:    map[string]topSellerH{"A": sku(10, 5, 5, 0, 30), "C": sku(5, -1, 4, 0, 30), "D": sku(5, 0, 4, 0, 30)},
+			want:    map[string]int{"A": 5},
+			dropped: []string{"C", "D"},
+			left:    50,
+		},
+		{
+			name:    "profit under minQt dropped",
+			goal:    goalProfit,
+			budget:  55,
+			skus:    map[string]topSellerH{"A": sku(10, 5, 5, 0, 30), "B": sku(10, 2, 10, 0, 30)},
+			want:    map[string]int{"A": 5},
+			dropped: []string{"B"},
+			left:    5,
+		},
+		{
+			name:   "no cost is free",
+			goal:   goalProfit,
+			budget: 10,
+			skus:   map[string]topSellerH{"A": sku(0, 5, 5, 0, 30), "B": sku(5, 2, 2, 0, 30)},
+			want:   map[string]int{"A": 5, "B": 2},
+			left:   0,
+		},
+		{
+			name:   "keeps the capacity cut",
+			goal:   goalProfit,
+			budget: 30,
+			skus:   map[string]topSellerH{"A": capped(sku(10, 5, 5, 0, 30))},
+			want:   map[string]int{"A": 3},
+			left:   0,
+		},
+		{
+			name:    "cover fewest days first",
+			goal:    goalCover,
+			budget:  40,
+			skus:    map[string]topSellerH{"A": sku(10, 5, 10, 0, 30), "B": sku(10, 5, 10, 10, 30)},
+			want:    map[string]int{"A": 4},
+			dropped: []string{"B"},
+			left:    0,
+		},
+		{
+			name:   "cover shares out",
+			goal:   goalCover,
+			budget: 100,
+			skus:   map[string]topSellerH{"A": sku(10, 5, 10, 0, 30), "B": sku(10, 5, 10, 4, 30)},
+			want:   map[string]int{"A": 7, "B": 3},
+			left:   0,
+		},
+	}
+	for _, tt := range tests {
+		e := &engine{opts: publishRequest{Budget: tt.budget, BudgetGoal: tt.goal}}
+		filz := &fbaStockFiles{
+			CAData:   map[string]topSellerH{},
+			caDays:   map[string]float64{},
+			Rejected: map[string]rejection{},
+		}
+		for s, v := range tt.skus {
+			filz.CAData[s] = v
+			filz.caDays[s] = 30
+		}
+
+		br := e.allocateBudget(filz)
+		checkAllocated(t, tt.name, rejOverBudget, filz, tt.skus, tt.want, tt.dropped)
+		if tt.budget == 0 {
+			if br != nil {
+				t.Errorf("%s: budget result %+v, want nil", tt.name, br)
+			}
+			continue
+		}
+		if br.Left != tt.left || br.Used != tt.budget-tt.left {
+			t.Errorf("%s: left %v used %v, want left %v", tt.name, br.Left, br.Used, tt.left)
+		}
+		// what is kept must cost what the budget says was used.
+		spent := 0.0
+		for _, v := range filz.CAData {
+			spent += float64(v.SugQt) * v.Cost
+		}
+		if spent != br.Used {
+			t.Errorf("%s: kept SKUs cost %v, budget used %v", tt.name, spent, br.Used)
+		}
+	}
+}
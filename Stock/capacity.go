@@ -0,0 +1,164 @@
+package stock
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rejOverCapacity is for SKUs cut back or dropped by the storage cap.
+const rejOverCapacity = "over_capacity"
+
+// capacityRules caps the cubic feet a run may send to FBA, from
+// rules.json Capacity. Standard and Oversize are the room left at
+// Amazon for each; 0 means no cap. SKUs sets the unit volume of items
+// SKU Vault has no dimensions for.
+type capacityRules struct {
+	Standard float64
+	Oversize float64
+	SKUs     map[string]capacitySKU
+}
+
+type capacitySKU struct {
+	// Volume is cubic feet a unit.
+	Volume   float64
+	Oversize bool `json:",omitempty"`
+}
+
+func (cr *capacityRules) check(probs *rulesErrors) {
+	if cr.Standard < 0 {
+		probs.add("Capacity.Standard", "must be 0 or more, got "+ftoa(cr.Standard))
+	}
+	if cr.Oversize < 0 {
+		probs.add("Capacity.Oversize", "must be 0 or more, got "+ftoa(cr.Oversize))
+	}
+	for _, sku := range sortedKeys(cr.SKUs) {
+		if v := cr.SKUs[sku].Volume; v <= 0 {
+			probs.add(`Capacity.SKUs["`+sku+`"].Volume`, "must be more than 0, got "+ftoa(v))
+		}
+	}
+}
+
+// qtCut is how a limit cut a SKU's SugQt down from FromQt.
+type qtCut struct {
+	Reason  string
+	FromQt  int
+	Numbers map[string]float64 `json:",omitempty"`
+}
+
+// String is the cut for the export, like "over_capacity from 12".
+func (c qtCut) String() string {
+	return c.Reason + " from " + strconv.Itoa(c.FromQt)
+}
+
+// qtCuts are every cut of a SKU, in the order the limits ran, so a
+// budget cut doesn't hide the capacity cut before it.
+type qtCuts []qtCut
+
+// String is the cuts for the export, like
+// "over_capacity from 12; over_budget from 9".
+func (cs qtCuts) String() string {
+	s := make([]string, len(cs))
+	for i, c := range cs {
+		s[i] = c.String()
+	}
+	return strings.Join(s, "; ")
+}
+
+// unitVolume is cubic feet a unit of sku and whether it is oversize.
+// Rules Capacity.SKUs wins over SKU Vault dimensions; ok is false when
+// neither has a size.
+func (e *engine) unitVolume(sku string, svd svData) (vol float64, oversize, ok bool) {
+	if c, ok := e.rules.Capacity.SKUs[sku]; ok {
+		return c.Volume, c.Oversize, true
+	}
+	tier, ok := e.fees.sizeTier(svd)
+	if !ok {
+		return 0, false, false
+	}
+	return svd.Length * svd.Width * svd.Height / 1728, tier.Oversize, true
+}
+
+// allocateCapacity fits the suggestions into the Capacity caps.
+//
+// Each cap is handed out by estimated profit per cubic foot, best
+// first. A SKU that doesn't fit whole is cut to the units that do; if
+// that is under minQt it is dropped with over_capacity.
+// SKUs with no known volume are left as they are.
+func (e *engine) allocateCapacity(filz *fbaStockFiles) {
+	cr := e.rules.Capacity
+	if cr.Standard == 0 && cr.Oversize == 0 {
+		return
+	}
+
+	type cand struct {
+		sku     string
+		vol     float64
+		perCuFt float64
+	}
+	pools := map[bool][]cand{}
+	noVol := []string{}
+	for sku, t := range filz.CAData {
+		vol, oversize, ok := e.unitVolume(sku, t.svData)
+		if !ok || vol <= 0 {
+			noVol = append(noVol, sku)
+			continue
+		}
+		pools[oversize] = append(pools[oversize], cand{sku, vol, t.EstProf / vol})
+	}
+	if len(noVol) > 0 {
+		sort.Strings(noVol)
+		logP(strconv.Itoa(len(noVol)) + " SKUs with no volume not held to capacity: " + strings.Join(noVol, ", "))
+	}
+
+	for _, oversize := range []bool{false, true} {
+		room := cr.Standard
+		if oversize {
+			room = cr.Oversize
+		}
+		if room == 0 {
+			continue
+		}
+		cands := pools[oversize]
+		sort.Slice(cands, func(i, j int) bool {
+			if cands[i].perCuFt != cands[j].perCuFt {
+				return cands[i].perCuFt > cands[j].perCuFt
+			}
+			return cands[i].sku < cands[j].sku
+		})
+
+		left := room
+		for _, c := range cands {
+			t := filz.CAData[c.sku]
+			fit := int(math.Floor(left/c.vol + 1e-9))
+			if fit >= t.SugQt {
+				left -= float64(t.SugQt) * c.vol
+				continue
+			}
+			nums := map[string]float64{
+				"SugQt":       float64(t.SugQt),
+				"UnitVolume":  c.vol,
+				"ProfPerCuFt": c.perCuFt,
+				"RoomLeft":    left,
+				"Capacity":    room,
+			}
+			if fit < minQt {
+				filz.reject(c.sku, rejOverCapacity, nums)
+				continue
+			}
+			t.Cuts = append(t.Cuts, qtCut{rejOverCapacity, t.SugQt, nums})
+			t.SugQt = fit
+			left -= float64(fit) * c.vol
+			filz.CAData[c.sku] = t
+		}
+		logP("capacity " + tierName(oversize) + ": " + ftoa(room-left) + " of " + ftoa(room) + " cu ft used, " + strconv.Itoa(len(cands)) + " SKUs")
+	}
+}
+
+func tierName(oversize bool) string {
+	if oversize {
+		return "oversize"
+	}
+	return "standard"
+}
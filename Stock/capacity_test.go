@@ -0,0 +1,139 @@
+package stock
+
+import "testing"
+
+// checkAllocated checks an allocator left filz holding want's SKUs at
+// those quantities, with a cut by reason on each it lowered from the
+// quantity in before, and dropped the dropped SKUs with reason.
+func checkAllocated(t *testing.T, name, reason string, filz *fbaStockFiles, before map[string]topSellerH, want map[string]int, dropped []string) {
+	t.Helper()
+	if len(filz.CAData) != len(want) {
+		t.Errorf("%s: kept %v, want %v", name, sortedKeys(filz.CAData), want)
+	}
+	for sku, qt := range want {
+		got := filz.CAData[sku]
+		if got.SugQt != qt {
+			t.Errorf("%s: %s SugQt = %d, want %d", name, sku, got.SugQt, qt)
+		}
+		from, cuts := before[sku].SugQt, before[sku].Cuts
+		switch {
+		case qt == from && len(got.Cuts) != len(cuts):
+			t.Errorf("%s: %s cut %v but kept whole", name, sku, got.Cuts)
+		case qt != from && len(got.Cuts) != len(cuts)+1:
+			t.Errorf("%s: %s cuts = %v, want %s from %d after %v", name, sku, got.Cuts, reason, from, cuts)
+		case qt != from:
+			if c := got.Cuts[len(cuts)]; c.Reason != reason || c.FromQt != from {
+				t.Errorf("%s: %s cut = %v, want %s from %d", name, sku, c, reason, from)
+			}
+			if got.Cuts[:len(cuts)].String() != cuts.String() {
+				t.Errorf("%s: %s cuts = %v, want %v kept", name, sku, got.Cuts, cuts)
+			}
+		}
+	}
+	for _, sku := range dropped {
+		if filz.Rejected[sku].Reason != reason {
+			t.Errorf("%s: %s rejection = %+v, want %s", name, sku, filz.Rejected[sku], reason)
+		}
+	}
+}
+
+func TestAllocateCapacity(t *testing.T) {
+	tbl, err := loadFeeTable(defFeeTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sku := func(prof float64, sugQt int) topSellerH {
+		return topSellerH{SugQt: sugQt, EstProf: prof}
+	}
+	// 12×12×6in is half a cubic foot of standard size.
+	boxed := func(prof float64, sugQt int) topSellerH {
+		t := sku(prof, sugQt)
+		t.Length, t.Width, t.Height, t.Weight = 12, 12, 6, 1
+		return t
+	}
+	vols := map[string]capacitySKU{
+		"A":   {Volume: 1},
+		"B":   {Volume: 2},
+		"C":   {Volume: 1},
+		"BIG": {Volume: 4, Oversize: true},
+	}
+	tests := []struct {
+		name               string
+		standard, oversize float64
+		skus               map[string]topSellerH
+		want               map[string]int
+		dropped            []string
+	}{
+		{
+			name: "no cap",
+			skus: map[string]topSellerH{"A": sku(5, 40)},
+			want: map[string]int{"A": 40},
+		},
+		{
+			name:     "all fit",
+			standard: 100,
+			skus:     map[string]topSellerH{"A": sku(5, 4), "B": sku(4, 4)},
+			want:     map[string]int{"A": 4, "B": 4},
+		},
+		{
+			// per cubic foot A makes 5, B 2 and C 1, though B makes
+			// the most a unit.
+			name:     "best per cubic foot first",
+			standard: 10,
+			skus:     map[string]topSellerH{"A": sku(5, 4), "B": sku(4, 4), "C": sku(1, 3)},
+			want:     map[string]int{"A": 4, "B": 3},
+			dropped:  []string{"C"},
+		},
+		{
+			name:     "cut under minQt dropped",
+			standard: 5,
+			skus:     map[string]topSellerH{"A": sku(5, 4), "B": sku(4, 4)},
+			want:     map[string]int{"A": 4},
+			dropped:  []string{"B"},
+		},
+		{
+			name:     "oversize has its own cap",
+			standard: 4,
+			oversize: 8,
+			skus:     map[string]topSellerH{"A": sku(5, 4), "BIG": sku(1, 5)},
+			want:     map[string]int{"A": 4, "BIG": 2},
+		},
+		{
+			name:     "SKU Vault dimensions",
+			standard: 2,
+			skus:     map[string]topSellerH{"BOX": boxed(5, 6)},
+			want:     map[string]int{"BOX": 4},
+		},
+		{
+			name:     "rules volume wins over SKU Vault",
+			standard: 2,
+			skus:     map[string]topSellerH{"A": boxed(5, 6)},
+			want:     map[string]int{"A": 2},
+		},
+		{
+			name:     "no volume left alone",
+			standard: 1,
+			skus:     map[string]topSellerH{"NOSIZE": sku(5, 40)},
+			want:     map[string]int{"NOSIZE": 40},
+		},
+	}
+	for _, tt := range tests {
+		rl := &rulesFile{}
+		rl.Capacity = capacityRules{Standard: tt.standard, Oversize: tt.oversize, SKUs: vols}
+		e := &engine{rules: rl, fees: tbl}
+		filz := &fbaStockFiles{CAData: map[string]topSellerH{}, Rejected: map[string]rejection{}}
+		for s, v := range tt.skus {
+			filz.CAData[s] = v
+		}
+
+		e.allocateCapacity(filz)
+		checkAllocated(t, tt.name, rejOverCapacity, filz, tt.skus, tt.want, tt.dropped)
+		for s, v := range filz.CAData {
+			for _, c := range v.Cuts {
+				if c.Numbers["RoomLeft"] > c.Numbers["Capacity"] {
+					t.Errorf("%s: %s cut with %v cu ft left of %v", tt.name, s, c.Numbers["RoomLeft"], c.Numbers["Capacity"])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package stock
+
+import (
+	"math"
+	"time"
+)
+
+// getSugQt modes set by rules QtMode.
+const (
+	// qtCoverage is average daily sales × DaysCoverd × SalesMultiplier.
+	qtCoverage = "coverage"
+	// qtSafety is demand over lead time and coverage plus safety stock,
+	// less what FBA has on hand and inbound (fbaQt).
+	qtSafety = "safety"
+)
+
+// salesPeriod is the units a SKU sold over one CA report date range.
+type salesPeriod struct {
+	start time.Time
+	days  float64
+	qty   int
+}
+
+func (sp salesPeriod) daily() float64 {
+	if sp.days <= 0 {
+		return 0
+	}
+	return float64(sp.qty) / sp.days
+}
+
+// dailySpread estimates the standard deviation of daily sales for sku.
+//
+// With several CA date ranges it uses how much the daily rate moved
+// between them, scaled back up to a single day. With one range it
+// falls back to Poisson demand, where the variance is the mean.
+func dailySpread(periods []salesPeriod, mean float64) float64 {
+	if len(periods) < 2 {
+		return math.Sqrt(mean)
+	}
+
+	var sum, days float64
+	for _, p := range periods {
+		sum += p.daily()
+		days += p.days
+	}
+	avg := sum / float64(len(periods))
+
+	var ss float64
+	for _, p := range periods {
+		d := p.daily() - avg
+		ss += d * d
+	}
+	sd := math.Sqrt(ss / float64(len(periods)-1))
+	return sd * math.Sqrt(days/float64(len(periods)))
+}
+
+// safetyStock is z × σ × √(lead time + coverage) for the SKU's service level.
+func (filz *fbaStockFiles) safetyStock(sku string, mean float64, rule skuRule) float64 {
+	horizon := float64(rule.LeadTimeDays + rule.DaysCoverd)
+	sd := dailySpread(filz.periods[sku], mean) * rule.SalesMultiplier
+	return zScore(rule.ServiceLevel) * sd * math.Sqrt(horizon)
+}
+
+// zScore is the inverse of the standard normal CDF, using Acklam's
+// rational approximation. p must be between 0 and 1.
+func zScore(p float64) float64 {
+	a := [...]float64{-3.969683028665376e+01, 2.209460984245205e+02, -2.759285104469687e+02, 1.383577518672690e+02, -3.066479806614716e+01, 2.506628277459239e+00}
+	b := [...]float64{-5.447609879822406e+01, 1.615858368580409e+02, -1.556989798598866e+02, 6.680131188771972e+01, -1.328068155288572e+01}
+	c := [...]float64{-7.784894002430293e-03, -3.223964580411365e-01, -2.400758277161838e+00, -2.549732539343734e+00, 4.374664141464968e+00, 2.938163982698783e+00}
+	d := [...]float64{7.784695709041462e-03, 3.224671290700398e-01, 2.445134137142996e+00, 3.754408661907416e+00}
+
+	const low = 0.02425
+	switch {
+	case p <= 0:
+		return math.Inf(-1)
+	case p >= 1:
+		return math.Inf(1)
+	case p < low:
+		q := math.Sqrt(-2 * math.Log(p))
+		return (((((c[0]*q+c[1])*q+c[2])*q+c[3])*q+c[4])*q + c[5]) /
+			((((d[0]*q+d[1])*q+d[2])*q+d[3])*q + 1)
+	case p > 1-low:
+		q := math.Sqrt(-2 * math.Log(1-p))
+		return -(((((c[0]*q+c[1])*q+c[2])*q+c[3])*q+c[4])*q + c[5]) /
+			((((d[0]*q+d[1])*q+d[2])*q+d[3])*q + 1)
+	}
+	q := p - 0.5
+	r := q * q
+	return (((((a[0]*r+a[1])*r+a[2])*r+a[3])*r+a[4])*r + a[5]) * q /
+		(((((b[0]*r+b[1])*r+b[2])*r+b[3])*r+b[4])*r + 1)
+}
@@ -0,0 +1,232 @@
+package stock
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// reportKind is the type of a report file, found from its header row.
+type reportKind int
+
+const (
+	unknownReport reportKind = iota
+	caReport
+	restockReport
+	viewsReport
+)
+
+func (k reportKind) String() string {
+	switch k {
+	case caReport:
+		return "CA data"
+	case restockReport:
+		return "Restock Report"
+	case viewsReport:
+		return "Business Report"
+	}
+	return "unknown"
+}
+
+var (
+	labelEx = regexp.MustCompile(`[A-Za-z]+`)
+
+	// reportTypes are the candidate reports. Columns are the fields
+	// excel.Unmarshal looks up; the filename regex is only a tie breaker.
+	reportTypes = []struct {
+		kind reportKind
+		cols []string
+		hint *regexp.Regexp
+	}{
+		{caReport, fieldNames(top{}), caData},
+		{restockReport, fieldNames(fbaRestockF{}), fbaRestock},
+		{viewsReport, fieldNames(amzViewsH{}), amzViews},
+	}
+)
+
+// fieldNames lists the field names of struct v that a report must have.
+// Fields tagged `report:"optional"` are left out.
+func fieldNames(v interface{}) []string {
+	return structCols(v, false)
+}
+
+// optionalNames lists the fields of struct v tagged `report:"optional"`.
+func optionalNames(v interface{}) []string {
+	return structCols(v, true)
+}
+
+func structCols(v interface{}, optional bool) []string {
+	t := reflect.TypeOf(v)
+	names := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if (f.Tag.Get("report") == "optional") == optional {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+// headerRow finds the header row the same way excel does: the longest
+// row whose cells all hold letters.
+func headerRow(rows [][]string) []string {
+	i := headerIndex(rows)
+	if i < 0 {
+		return nil
+	}
+	return rows[i]
+}
+
+// headerIndex is the index of headerRow in rows, or -1.
+func headerIndex(rows [][]string) int {
+	n := -1
+	longest := 0
+	for i, cells := range rows {
+		if len(cells) <= longest {
+			continue
+		}
+		isKeys := true
+		for _, cell := range cells {
+			if !labelEx.MatchString(cell) {
+				isKeys = false
+				break
+			}
+		}
+		if isKeys {
+			longest = len(cells)
+			n = i
+		}
+	}
+	return n
+}
+
+// addColumn returns a copy of rows with a column named col after the
+// header; val gives the value for each body row.
+func addColumn(rows [][]string, col string, val func(row []string) string) [][]string {
+	h := headerIndex(rows)
+	out := make([][]string, len(rows))
+	for i, row := range rows {
+		switch {
+		case i == h:
+			out[i] = append(append([]string{}, row...), col)
+		case i > h && h >= 0:
+			out[i] = append(append([]string{}, row...), val(row))
+		default:
+			out[i] = row
+		}
+	}
+	return out
+}
+
+// colIndex is the index of the header cell named col, ignoring case,
+// or -1.
+func colIndex(header []string, col string) int {
+	for i, cell := range header {
+		if strings.EqualFold(strings.TrimSpace(cell), col) {
+			return i
+		}
+	}
+	return -1
+}
+
+// fillOptional adds an empty column for each optional field of v the
+// report does not have, so excel.Unmarshal can still find it.
+func fillOptional(rows [][]string, v interface{}) [][]string {
+	for _, col := range optionalNames(v) {
+		if !hasCol(col, headerRow(rows)) {
+			rows = addColumn(rows, col, func([]string) string { return "" })
+		}
+	}
+	return rows
+}
+
+// restockReserved adds a Reserved column to a Restock Report that
+// splits reserved units into FC transfer, FC Processing and Customer
+// Order.
+func restockReserved(rows [][]string) [][]string {
+	header := headerRow(rows)
+	if colIndex(header, "Reserved") >= 0 {
+		return rows
+	}
+
+	parts := []int{}
+	for _, col := range []string{"FC transfer", "FC Processing", "Customer Order"} {
+		if i := colIndex(header, col); i >= 0 {
+			parts = append(parts, i)
+		}
+	}
+	if len(parts) == 0 {
+		return rows
+	}
+
+	return addColumn(rows, "Reserved", func(row []string) string {
+		sum := 0
+		for _, i := range parts {
+			if i < len(row) {
+				n, _ := strconv.Atoi(strings.Replace(strings.TrimSpace(row[i]), ",", "", -1))
+				sum += n
+			}
+		}
+		return strconv.Itoa(sum)
+	})
+}
+
+// hasCol reports whether a header cell abbreviates to col, using the
+// same letters-in-order match as excel.Unmarshal.
+func hasCol(col string, header []string) bool {
+	expr := `(?i)`
+	for i, r := range col {
+		end := `.*`
+		if i+1 < len(col) {
+			end = `[^` + string(col[i+1]) + `]*`
+		}
+		expr += string(r) + end
+	}
+	ex := regexp.MustCompile(expr)
+	for _, cell := range header {
+		if len(cell) > 0 && ex.MatchString(cell) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectReport works out the report type of rows from its header.
+// When more than one type fits, name decides.
+func detectReport(name string, rows [][]string) (reportKind, error) {
+	header := headerRow(rows)
+
+	fits := []reportKind{}
+	missing := []string{}
+	for _, rt := range reportTypes {
+		miss := []string{}
+		for _, col := range rt.cols {
+			if !hasCol(col, header) {
+				miss = append(miss, col)
+			}
+		}
+		if len(miss) > 0 {
+			missing = append(missing, rt.kind.String()+" missing "+strings.Join(miss, ", "))
+			continue
+		}
+		fits = append(fits, rt.kind)
+	}
+
+	switch len(fits) {
+	case 0:
+		return unknownReport, errors.New(`detectReport: ` + name + ` not recognized: ` + strings.Join(missing, "; "))
+	case 1:
+		return fits[0], nil
+	}
+
+	for _, rt := range reportTypes {
+		for _, k := range fits {
+			if rt.kind == k && rt.hint.MatchString(name) {
+				return k, nil
+			}
+		}
+	}
+	return fits[0], nil
+}
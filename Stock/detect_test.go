@@ -0,0 +1,178 @@
+package stock
+
+import (
+	"strings"
+	"testing"
+)
+
+// csvRows splits a small CSV with no quoted commas.
+func csvRows(s string) [][]string {
+	rows := [][]string{}
+	for _, ln := range strings.Split(strings.TrimSpace(s), "\n") {
+		rows = append(rows, strings.Split(ln, ","))
+	}
+	return rows
+}
+
+func TestDetectReport(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		csv  string
+		want reportKind
+		err  string
+	}{
+		{
+			name: "ChannelAdvisor",
+			file: "CA data.csv",
+			csv:  "Report Start Date,Report End Date,Title,Qty Sold,GMV,SKU\n2018-01-01,2018-01-31,Red Shirt,12,240.00,RED-1",
+			want: caReport,
+		},
+		{
+			name: "ChannelAdvisor reordered with extra columns",
+			file: "sales.csv",
+			csv:  "SKU,Marketplace,Title,Qty Sold,GMV,Report Start Date,Report End Date\nRED-1,Amazon US,Red Shirt,12,240.00,2018-01-01,2018-01-31",
+			want: caReport,
+		},
+		{
+			name: "Restock Report",
+			file: "download.csv",
+			csv: "Country,Product Name,FNSKU,Merchant SKU,ASIN,Condition,Supplier,Supplier part no.,Currency code,Price,Sales last 30 days,Units Sold Last 30 Days,Total Units,Inbound,Available,FC transfer,FC Processing,Customer Order,Unfulfillable,Fulfilled by,Days of Supply,Alert,Recommended Order Quantity,Recommended Order Date\n" +
+				"US,Red Shirt,X001,RED-1,B02,New,,,USD,20,240,12,20,2,10,1,2,3,0,Amazon,30,out_of_stock,10,2018-02-01",
+			want: restockReport,
+		},
+		{
+			name: "Business Report",
+			file: "BusinessReport-2-1-18.csv",
+			csv: "(Parent) ASIN,(Child) ASIN,Title,SKU,Sessions,Session Percentage,Page Views,Page Views Percentage,Buy Box Percentage,Units Ordered,Unit Session Percentage,Ordered Product Sales,Total Order Items\n" +
+				"B01,B02,Red Shirt,RED-1,40,1%,55,1%,98%,8,20%,$160.00,8",
+			want: viewsReport,
+		},
+		{
+			name: "Business Report with totals columns",
+			file: "report.csv",
+			csv: "(Parent) ASIN,(Child) ASIN,Title,SKU,Sessions - Total,Session Percentage - Total,Page Views - Total,Page Views Percentage - Total,Featured Offer (Buy Box) Percentage,Units Ordered,Unit Session Percentage,Ordered Product Sales,Total Order Items\n" +
+				"B01,B02,Red Shirt,RED-1,40,1%,55,1%,98%,8,20%,$160.00,8",
+			want: viewsReport,
+		},
+		{
+			name: "fits two, name decides",
+			file: "Restock Report.csv",
+			csv:  "Report Start Date,Report End Date,Title,Qty Sold,GMV,SKU,Inbound,Alert,Recommended Order Quantity,Recommended Order Date\n2018-01-01,2018-01-31,Red Shirt,12,240.00,RED-1,2,,10,2018-02-01",
+			want: restockReport,
+		},
+		{
+			name: "fits two, no name",
+			file: "both.csv",
+			csv:  "Report Start Date,Report End Date,Title,Qty Sold,GMV,SKU,Inbound,Alert,Recommended Order Quantity,Recommended Order Date\n2018-01-01,2018-01-31,Red Shirt,12,240.00,RED-1,2,,10,2018-02-01",
+			want: caReport,
+		},
+		{
+			name: "not a report",
+			file: "Restock Report.csv",
+			csv:  "Date,Amount,Description\n2018-01-01,5.00,coffee",
+			err:  "Restock Report.csv not recognized: CA data missing ReportStartDate, ReportEndDate",
+		},
+		{
+			name: "empty",
+			file: "empty.csv",
+			err:  "empty.csv not recognized",
+		},
+	}
+	for _, tt := range tests {
+		got, err := detectReport(tt.file, csvRows(tt.csv))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: detectReport = %v, %v; want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestRestockReserved(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		want string
+	}{
+		{
+			name: "split reserved",
+			csv:  "Merchant SKU,FC transfer,FC Processing,Customer Order\nRED-1,1,2,3\nBLUE-1,,,4",
+			want: "Merchant SKU,FC transfer,FC Processing,Customer Order,Reserved\nRED-1,1,2,3,6\nBLUE-1,,,4,4",
+		},
+		{
+			name: "one part",
+			csv:  "Merchant SKU,Customer Order\nRED-1,3",
+			want: "Merchant SKU,Customer Order,Reserved\nRED-1,3,3",
+		},
+		{
+			name: "has Reserved",
+			csv:  "Merchant SKU,Reserved,FC transfer\nRED-1,5,1",
+			want: "Merchant SKU,Reserved,FC transfer\nRED-1,5,1",
+		},
+		{
+			name: "no parts",
+			csv:  "Merchant SKU,Inbound\nRED-1,2",
+			want: "Merchant SKU,Inbound\nRED-1,2",
+		},
+	}
+	for _, tt := range tests {
+		got := restockReserved(csvRows(tt.csv))
+		if s := joinRows(got); s != tt.want {
+			t.Errorf("%s: restockReserved =\n%s\nwant\n%s", tt.name, s, tt.want)
+		}
+	}
+}
+
+func TestRestockReservedCommas(t *testing.T) {
+	rows := [][]string{
+		{"Merchant SKU", "FC transfer", "Customer Order"},
+		{"RED-1", "1,200", " 4 "},
+	}
+	got := restockReserved(rows)
+	if v := got[1][len(got[1])-1]; v != "1204" {
+		t.Errorf("Reserved = %s, want 1204", v)
+	}
+}
+
+func TestFillOptional(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		want string
+	}{
+		{
+			name: "all missing",
+			csv:  "SKU,Inbound,Alert,Recommended Order Quantity,Recommended Order Date\nRED-1,2,,10,2018-02-01",
+			want: "SKU,Inbound,Alert,Recommended Order Quantity,Recommended Order Date,Available,Reserved,Unfulfillable\nRED-1,2,,10,2018-02-01,,,",
+		},
+		{
+			name: "some there",
+			csv:  "SKU,Available,Inbound,Alert,Recommended Order Quantity,Recommended Order Date,Unfulfillable\nRED-1,7,2,,10,2018-02-01,1",
+			want: "SKU,Available,Inbound,Alert,Recommended Order Quantity,Recommended Order Date,Unfulfillable,Reserved\nRED-1,7,2,,10,2018-02-01,1,",
+		},
+		{
+			name: "preamble kept",
+			csv:  "Restock Inventory\nSKU,Inbound,Alert,Recommended Order Quantity,Recommended Order Date,Available,Reserved,Unfulfillable\nRED-1,2,,10,2018-02-01,7,1,0",
+			want: "Restock Inventory\nSKU,Inbound,Alert,Recommended Order Quantity,Recommended Order Date,Available,Reserved,Unfulfillable\nRED-1,2,,10,2018-02-01,7,1,0",
+		},
+	}
+	for _, tt := range tests {
+		got := fillOptional(csvRows(tt.csv), fbaRestockF{})
+		if s := joinRows(got); s != tt.want {
+			t.Errorf("%s: fillOptional =\n%s\nwant\n%s", tt.name, s, tt.want)
+		}
+	}
+}
+
+func joinRows(rows [][]string) string {
+	lines := []string{}
+	for _, r := range rows {
+		lines = append(lines, strings.Join(r, ","))
+	}
+	return strings.Join(lines, "\n")
+}
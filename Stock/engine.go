@@ -0,0 +1,109 @@
+package stock
+
+import "github.com/OuttaLineNomad/skuvault"
+
+// engine is one Stock request: its rules, options and clients.
+//
+// Nothing in an engine changes once a run starts, and runs work on
+// their own copy of the report data, so engines with different rules
+// can run side by side over the same reports.
+type engine struct {
+	rules *rulesFile
+	opts  publishRequest
+	fees  *feeTable
+	sv    *skuvault.Ctr
+	// svd is SKU Vault data fetched up front for several runs; with none
+	// each run fetches its own.
+	svd svDatas
+}
+
+// newEngine sets up an engine for the request p. It has no rules until
+// withRules is called.
+func newEngine(p publishRequest) *engine {
+	return &engine{
+		opts: p,
+		sv:   skuvault.NewEnvCredSession(),
+	}
+}
+
+// withRules returns a copy of e that runs with rl and its fee table.
+func (e *engine) withRules(rl *rulesFile) (*engine, error) {
+	tbl, err := loadFeeTable(rl.Fees.Table)
+	if err != nil {
+		return nil, err
+	}
+	ne := *e
+	ne.rules = rl
+	ne.fees = tbl
+	return &ne, nil
+}
+
+// withSvData returns a copy of e whose runs use svd, which must hold
+// every SKU they ask for, in place of calling SKU Vault.
+func (e *engine) withSvData(svd svDatas) *engine {
+	ne := *e
+	ne.svd = svd
+	return &ne
+}
+
+// run works out suggestions for data with e's rules. data is not
+// changed; the results are in the copy returned.
+func (e *engine) run(data *fbaStockFiles) (*fbaStockFiles, error) {
+	filz := data.clone()
+
+	// rollup goes first so variants each under Topseller still pool
+	// their demand.
+	if e.opts.ParentRollup {
+		logP("rolling children up to parent ASINs...")
+		filz.rollupParents()
+	}
+
+	// overrides may lower Topseller; getSuggestion checks the SKU's own.
+	// Rolled up children are judged on their parent's units.
+	filz.Rejected = make(map[string]rejection)
+	min := e.rules.minTopseller()
+	for sku, t := range filz.CAData {
+		qtySold := t.QtySold
+		if pr, ok := filz.parents[sku]; ok {
+			qtySold = pr.QtySold
+		}
+		if qtySold < min {
+			filz.reject(sku, rejBelowTopseller, map[string]float64{
+				"QtySold":   float64(qtySold),
+				"Topseller": float64(min),
+			})
+		}
+	}
+
+	if err := e.getSuggestion(filz); err != nil {
+		return nil, err
+	}
+	return filz, nil
+}
+
+// clone copies the maps a run changes. Slices in periods are copied too
+// as addHistory appends to them.
+func (filz *fbaStockFiles) clone() *fbaStockFiles {
+	c := *filz
+
+	c.CAData = make(map[string]topSellerH, len(filz.CAData))
+	for k, v := range filz.CAData {
+		c.CAData[k] = v
+	}
+	c.FBARestock = make(map[string]fbaRestockH, len(filz.FBARestock))
+	for k, v := range filz.FBARestock {
+		c.FBARestock[k] = v
+	}
+	c.caDays = make(map[string]float64, len(filz.caDays))
+	for k, v := range filz.caDays {
+		c.caDays[k] = v
+	}
+	c.periods = make(map[string][]salesPeriod, len(filz.periods))
+	for k, v := range filz.periods {
+		c.periods[k] = append([]salesPeriod(nil), v...)
+	}
+	c.Rejected = nil
+	c.parents = nil
+	c.seasons = nil
+	return &c
+}
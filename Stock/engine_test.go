@@ -0,0 +1,74 @@
+package stock
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunRejectsBelowTopseller(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtures(t, dir, reportFixtures)
+	reports, err := getReports(dirSource{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		rules string
+		brand string
+		want  rejection
+	}{
+		{
+			name:  "global Topseller",
+			rules: `{"Topseller": 5, "Fees": {"FBAClass": {"default": 3}}}`,
+			want:  rejection{rejBelowTopseller, map[string]float64{"QtySold": 3, "Topseller": 5}},
+		},
+		{
+			name:  "another brand's lower Topseller",
+			rules: `{"Topseller": 5, "Brands": {"Acme": {"Topseller": 2}}, "Fees": {"FBAClass": {"default": 3}}}`,
+			brand: "Other",
+			want:  rejection{rejBelowTopseller, map[string]float64{"QtySold": 3, "Topseller": 5}},
+		},
+		{
+			// BLUE-1 passes on its brand's Topseller, then has no
+			// Business Report price.
+			name:  "own brand's lower Topseller",
+			rules: `{"Topseller": 5, "Brands": {"Acme": {"Topseller": 2}}, "Fees": {"FBAClass": {"default": 3}}}`,
+			brand: "Acme",
+			want:  rejection{Reason: rejNoPrice},
+		},
+	}
+	for _, tt := range tests {
+		rl, err := loadRules(strings.NewReader(tt.rules))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := (&engine{}).withRules(rl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e = e.withSvData(svDatas{
+			"RED-1":  {Cost: 5, AvailableQt: 100, Brand: tt.brand},
+			"BLUE-1": {Cost: 5, AvailableQt: 100, Brand: tt.brand},
+		})
+
+		out, err := e.run(reports)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := out.Rejected["BLUE-1"]
+		if !ok || got.Reason != tt.want.Reason {
+			t.Errorf("%s: BLUE-1 rejection = %+v, want %s", tt.name, got, tt.want.Reason)
+			continue
+		}
+		for k, v := range tt.want.Numbers {
+			if got.Numbers[k] != v {
+				t.Errorf("%s: BLUE-1 %s = %v, want %v", tt.name, k, got.Numbers[k], v)
+			}
+		}
+		if _, ok := reports.CAData["BLUE-1"]; !ok {
+			t.Fatalf("%s: run changed the reports it was given", tt.name)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package stock
+
+// Reason codes for SKUs dropped from Suggested.
+const (
+	rejNoSkuVault     = "not_in_skuvault"
+	rejBelowTopseller = "below_topseller"
+	rejNoPrice        = "no_price"
+	rejLowProfit      = "low_profit"
+	rejFBACovered     = "fba_covered"
+	rejNoStock        = "no_stock"
+	rejInboundCovers  = "inbound_covers"
+	rejBelowMinQt     = "below_min_qty"
+)
+
+// rejection is why a SKU was dropped, with the numbers behind it.
+type rejection struct {
+	Reason  string
+	Numbers map[string]float64 `json:",omitempty"`
+}
+
+// skuTrace is the full calculation for a kept SKU, sent with explain.
+type skuTrace struct {
+	Rule      skuRule
+	EstPrice  float64
+	TotalCost float64
+	EstProf   float64
+	Qt        qtTrace
+}
+
+// qtTrace is each step getSugQt took.
+type qtTrace struct {
+	Mode       string
+	Forecast   string
+	DailySales float64
+	// Days is the coverage window, plus lead time in safety mode.
+	Days float64
+	// Season scales DailySales to the weeks ahead; 1 when flat.
+	Season float64
+	Demand float64
+	Safety float64
+	// Target is the units wanted at Amazon; Need is Target less FBAQt,
+	// the FBA stock netted off in safety mode or with NetFBA.
+	Target    int
+	FBAQt     int
+	Need      int
+	Available int
+	SVInbound int
+	SugQt     int
+}
+
+func (filz *fbaStockFiles) reject(sku, reason string, numbers map[string]float64) {
+	delete(filz.CAData, sku)
+	filz.Rejected[sku] = rejection{reason, numbers}
+}
+
+// reason picks the rejection code for a trace that ended with no units.
+func (tr qtTrace) reason() string {
+	capped := tr.Need
+	if capped > tr.Available {
+		capped = tr.Available
+	}
+	switch {
+	case tr.Need <= 0 && tr.FBAQt > 0:
+		return rejFBACovered
+	case tr.Available <= 0:
+		return rejNoStock
+	case tr.SVInbound > 0 && capped >= minQt:
+		return rejInboundCovers
+	}
+	return rejBelowMinQt
+}
+
+func (tr qtTrace) numbers() map[string]float64 {
+	return map[string]float64{
+		"DailySales": tr.DailySales,
+		"Days":       tr.Days,
+		"Season":     tr.Season,
+		"Demand":     tr.Demand,
+		"Safety":     tr.Safety,
+		"Target":     float64(tr.Target),
+		"FBAQt":      float64(tr.FBAQt),
+		"Need":       float64(tr.Need),
+		"Available":  float64(tr.Available),
+		"SVInbound":  float64(tr.SVInbound),
+	}
+}
@@ -0,0 +1,175 @@
+package stock
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tealeg/xlsx"
+	"google.golang.org/api/drive/v3"
+)
+
+// Export formats for Stock results.
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatXLSX = "xlsx"
+
+	mimeCSV  = "text/csv"
+	mimeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// exportSheet is one tab of an export.
+type exportSheet struct {
+	Name   string
+	Header []string
+	Rows   [][]interface{}
+}
+
+// exportFormat picks the format from the request, then the Accept header.
+func exportFormat(p publishRequest, r *http.Request) (string, error) {
+	switch strings.ToLower(p.Format) {
+	case "", formatJSON:
+	case formatCSV:
+		return formatCSV, nil
+	case formatXLSX:
+		return formatXLSX, nil
+	case formatInbound:
+		return formatInbound, nil
+	default:
+		return "", errors.New("exportFormat: unknown format " + p.Format)
+	}
+
+	accept := r.Header.Get("Accept")
+	switch {
+	case p.Format == "" && strings.Contains(accept, mimeXLSX):
+		return formatXLSX, nil
+	case p.Format == "" && strings.Contains(accept, mimeCSV):
+		return formatCSV, nil
+	}
+	return formatJSON, nil
+}
+
+// sheets lays the response out as Suggested, FBARestock and Rejected tabs,
+// each sorted by SKU.
+func (resp *apiRespond) sheets() []exportSheet {
+	sug := exportSheet{
+		Name:   "Suggested",
+		Header: []string{"SKU", "Title", "Brand", "Class", "UPC", "QtySold", "Cost", "EstPrice", "Fees", "EstProf", "SugPrice", "SugProf", "PriceBasis", "SugQt", "SafetyQt", "FBAQt", "AvailableQt", "Restock", "Override", "Cuts"},
+	}
+	for _, sku := range sortedKeys(resp.Suggested) {
+		t := resp.Suggested[sku]
+		sug.Rows = append(sug.Rows, []interface{}{sku, t.Title, t.Brand, t.Class, t.UPC, t.QtySold, t.Cost, t.EstPrice, t.Fees.Total, t.EstProf, t.SugPrice, t.SugProf, t.PriceBasis, t.SugQt, t.SafetyQt, t.FBAQt, t.AvailableQt, t.Restock, t.Override, t.Cuts.String()})
+	}
+
+	rs := exportSheet{
+		Name:   "FBARestock",
+		Header: []string{"SKU", "Alert", "RecQt", "RecDate", "Inbound", "Available", "Reserved", "Unfulfillable", "Brand", "Cost", "AvailableQt"},
+	}
+	for _, sku := range sortedKeys(resp.FBARestock) {
+		f := resp.FBARestock[sku]
+		rs.Rows = append(rs.Rows, []interface{}{sku, f.Alert, f.RecQt, f.RecDate, f.Inbound, f.Available, f.Reserved, f.Unfulfillable, f.Brand, f.Cost, f.AvailableQt})
+	}
+
+	rej := exportSheet{
+		Name:   "Rejected",
+		Header: []string{"SKU", "Reason", "Numbers"},
+	}
+	for _, sku := range sortedKeys(resp.Rejected) {
+		r := resp.Rejected[sku]
+		nums := []string{}
+		for _, k := range sortedKeys(r.Numbers) {
+			nums = append(nums, k+"="+ftoa(r.Numbers[k]))
+		}
+		rej.Rows = append(rej.Rows, []interface{}{sku, r.Reason, strings.Join(nums, "; ")})
+	}
+
+	return []exportSheet{sug, rs, rej}
+}
+
+// writeCSV writes the Suggested tab as CSV.
+func (resp *apiRespond) writeCSV() ([]byte, error) {
+	sh := resp.sheets()[0]
+	buf := bytes.Buffer{}
+	c := csv.NewWriter(&buf)
+	c.Write(sh.Header)
+	for _, row := range sh.Rows {
+		rec := make([]string, len(row))
+		for i, v := range row {
+			rec[i] = cellString(v)
+		}
+		c.Write(rec)
+	}
+	c.Flush()
+	return buf.Bytes(), c.Error()
+}
+
+// writeXLSX writes every tab to one workbook.
+func (resp *apiRespond) writeXLSX() ([]byte, error) {
+	book := xlsx.NewFile()
+	for _, sh := range resp.sheets() {
+		tab, err := book.AddSheet(sh.Name)
+		if err != nil {
+			return nil, err
+		}
+		row := tab.AddRow()
+		for _, h := range sh.Header {
+			row.AddCell().SetString(h)
+		}
+		for _, r := range sh.Rows {
+			row := tab.AddRow()
+			for _, v := range r {
+				cell := row.AddCell()
+				switch v := v.(type) {
+				case bool:
+					cell.SetBool(v)
+				case time.Time:
+					cell.SetString(cellString(v))
+				default:
+					cell.SetValue(v)
+				}
+			}
+		}
+	}
+
+	buf := bytes.Buffer{}
+	if err := book.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func cellString(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return ftoa(v)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format("2006-01-02")
+	}
+	return fmt.Sprint(v)
+}
+
+// saveToDrive puts an export in the Drive folder next to the reports.
+func saveToDrive(src reportSource, name, mime string, b []byte) (string, error) {
+	d, ok := src.(*driveSource)
+	if !ok {
+		return "", errors.New("saveToDrive: reports did not come from Drive")
+	}
+
+	f, err := d.s.Drive.Save(b, &drive.File{
+		Name:     name,
+		MimeType: mime,
+		Parents:  []string{d.folderID},
+	})
+	if err != nil {
+		return "", err
+	}
+	return f.Id, nil
+}
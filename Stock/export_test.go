@@ -0,0 +1,136 @@
+package stock
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tealeg/xlsx"
+)
+
+// exportResp is a response with one SKU on each tab.
+func exportResp() *apiRespond {
+	sug := topSellerH{SugQt: 12, EstPrice: 20, EstProf: 9.5, Restock: true}
+	sug.Title = "Red Shirt"
+	sug.Brand = "Acme"
+	sug.QtySold = 30
+	sug.Cost = 5
+	sug.Fees.Total = 5.5
+	sug.Cuts = qtCuts{{Reason: rejOverCapacity, FromQt: 15}, {Reason: rejOverBudget, FromQt: 14}}
+
+	rs := fbaRestockH{}
+	rs.Alert = "out_of_stock"
+	rs.RecQt = 10
+	rs.RecDate = date(2, 1)
+	rs.Brand = "Acme"
+
+	return &apiRespond{
+		Suggested:  map[string]topSellerH{"RED-1": sug},
+		FBARestock: map[string]fbaRestockH{"BLUE-1": rs},
+		Rejected: map[string]rejection{
+			"GREEN-1": {rejBelowTopseller, map[string]float64{"Topseller": 5, "QtySold": 3}},
+		},
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	b, err := exportResp().writeCSV()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"SKU", "Title", "Brand", "Class", "UPC", "QtySold", "Cost", "EstPrice", "Fees", "EstProf", "SugPrice", "SugProf", "PriceBasis", "SugQt", "SafetyQt", "FBAQt", "AvailableQt", "Restock", "Override", "Cuts"},
+		{"RED-1", "Red Shirt", "Acme", "", "", "30", "5", "20", "5.5", "9.5", "0", "0", "", "12", "0", "0", "0", "true", "", "over_capacity from 15; over_budget from 14"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("writeCSV =\n%q\nwant\n%q", rows, want)
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	b, err := exportResp().writeXLSX()
+	if err != nil {
+		t.Fatal(err)
+	}
+	book, err := xlsx.OpenBinary(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		sheet  string
+		header []string
+		row    []string
+	}{
+		{
+			sheet:  "Suggested",
+			header: []string{"SKU", "Title", "Brand"},
+			row:    []string{"RED-1", "Red Shirt", "Acme"},
+		},
+		{
+			sheet:  "FBARestock",
+			header: []string{"SKU", "Alert", "RecQt", "RecDate"},
+			row:    []string{"BLUE-1", "out_of_stock", "10", "2018-02-01"},
+		},
+		{
+			sheet:  "Rejected",
+			header: []string{"SKU", "Reason", "Numbers"},
+			row:    []string{"GREEN-1", rejBelowTopseller, "QtySold=3; Topseller=5"},
+		},
+	}
+	if len(book.Sheets) != len(tests) {
+		t.Errorf("%d sheets, want %d", len(book.Sheets), len(tests))
+	}
+	for i, tt := range tests {
+		if i >= len(book.Sheets) {
+			break
+		}
+		sh := book.Sheets[i]
+		if sh.Name != tt.sheet {
+			t.Errorf("sheet %d = %s, want %s", i, sh.Name, tt.sheet)
+			continue
+		}
+		if len(sh.Rows) != 2 {
+			t.Errorf("%s has %d rows, want header and one SKU", sh.Name, len(sh.Rows))
+			continue
+		}
+		for r, want := range [][]string{tt.header, tt.row} {
+			for c, w := range want {
+				if got := sh.Rows[r].Cells[c].String(); got != w {
+					t.Errorf("%s row %d cell %d = %q, want %q", sh.Name, r, c, got, w)
+				}
+			}
+		}
+	}
+}
+
+func TestExportFormat(t *testing.T) {
+	tests := []struct {
+		format, accept string
+		want           string
+		err            bool
+	}{
+		{"", "", formatJSON, false},
+		{"", mimeXLSX, formatXLSX, false},
+		{"", "text/csv, application/json", formatCSV, false},
+		{"CSV", "", formatCSV, false},
+		{"json", mimeXLSX, formatJSON, false},
+		{"xlsx", mimeCSV, formatXLSX, false},
+		{formatInbound, "", formatInbound, false},
+		{"pdf", "", "", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/", strings.NewReader("{}"))
+		r.Header.Set("Accept", tt.accept)
+		got, err := exportFormat(publishRequest{Format: tt.format}, r)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("exportFormat(%q, Accept %q) = %q, %v; want %q", tt.format, tt.accept, got, err, tt.want)
+		}
+	}
+}
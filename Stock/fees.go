@@ -0,0 +1,248 @@
+package stock
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defFeeTable is the fee data file read when rules leave Fees.Table out.
+const defFeeTable = "fba_fees.json"
+
+// feeTables are the fee data files built into the function. Rules can
+// only pick one of these, so a new fee schedule ships as a new
+// fba_fees*.json next to this file.
+//
+//go:embed fba_fees*.json
+var feeTables embed.FS
+
+// feeTableNames lists the fee data files rules can pick.
+func feeTableNames() []string {
+	names, _ := fs.Glob(feeTables, "fba_fees*.json")
+	return names
+}
+
+// SKU Vault product attributes holding the item size and Amazon category.
+const (
+	attrLength   = "Length"
+	attrWidth    = "Width"
+	attrHeight   = "Height"
+	attrCategory = "Category"
+)
+
+// feeTable is Amazon's FBA fee schedule, read from a versioned data file.
+type feeTable struct {
+	Version  string
+	Referral map[string]struct {
+		Percent float64
+		Min     float64
+	}
+	DimDivisor float64
+	SizeTiers  []sizeTier
+	Storage    []struct {
+		Months   []time.Month
+		Standard float64
+		Oversize float64
+	}
+	// LowInventory is the surcharge under DaysSupply days of supply;
+	// schedules before 2024 have none.
+	LowInventory struct {
+		DaysSupply float64
+		Standard   float64
+		Oversize   float64
+	}
+}
+
+// sizeTier is one FBA product size tier. Zero limits are not checked.
+type sizeTier struct {
+	Name             string
+	MaxWeightLb      float64
+	MaxLongIn        float64
+	MaxMedianIn      float64
+	MaxShortIn       float64
+	MaxLengthGirthIn float64
+	PackagingLb      float64
+	DimWeight        bool
+	Oversize         bool
+	// Steps are flat fees up to a shipping weight.
+	Steps []struct {
+		UpToLb float64
+		Fee    float64
+	}
+	// Over is a base fee plus a rate for each pound over FromLb.
+	Over *struct {
+		FromLb float64
+		Fee    float64
+		PerLb  float64
+	}
+}
+
+// feeBreakdown is the estimated Amazon fees for one unit.
+type feeBreakdown struct {
+	Referral     float64
+	Fulfillment  float64
+	Storage      float64
+	LowInventory float64
+	Total        float64
+	SizeTier     string
+	Table        string
+}
+
+// loadFeeTable reads the built in fee data file name.
+func loadFeeTable(name string) (*feeTable, error) {
+	b, err := feeTables.ReadFile(name)
+	if err != nil {
+		return nil, errors.New(`no fee table ` + name + `; use one of ` + strings.Join(feeTableNames(), ", "))
+	}
+
+	tbl := &feeTable{}
+	if err := json.Unmarshal(b, tbl); err != nil {
+		return nil, errors.New(name + `: ` + err.Error())
+	}
+	if _, ok := tbl.Referral["default"]; !ok {
+		return nil, errors.New(name + `: Referral["default"] is required`)
+	}
+	if len(tbl.SizeTiers) == 0 {
+		return nil, errors.New(name + `: no SizeTiers`)
+	}
+	return tbl, nil
+}
+
+// getFees estimates FBA fees for one unit of svd sold at price. Items
+// SKU Vault has no size for get the rules FBAClass fee and no storage.
+func (e *engine) getFees(price float64, svd svData, daysSupply float64, rule skuRule) feeBreakdown {
+	tbl := e.fees
+	fb := feeBreakdown{Table: tbl.Version}
+
+	ref, ok := tbl.Referral[svd.Category]
+	if !ok {
+		ref = tbl.Referral["default"]
+		ref.Percent = e.rules.Fees.FeePercentage
+	}
+	fb.Referral = math.Max(price*ref.Percent, ref.Min)
+
+	tier, ok := tbl.sizeTier(svd)
+	if !ok {
+		classFee, ok := e.rules.Fees.FBAClass[svd.Class]
+		if !ok {
+			classFee = e.rules.Fees.FBAClass["default"]
+		}
+		fb.Fulfillment = classFee
+		fb.SizeTier = "unknown (FBAClass fee)"
+		fb.Total = fb.Referral + fb.Fulfillment
+		return fb
+	}
+	fb.SizeTier = tier.Name
+	fb.Fulfillment = tier.fee(tbl.shipWeight(tier, svd))
+
+	cuFt := svd.Length * svd.Width * svd.Height / 1728
+	months := float64(rule.DaysCoverd) / 30 / 2
+	fb.Storage = tbl.storageRate(tier, time.Now().Month()) * cuFt * months
+
+	low := tbl.LowInventory
+	if low.DaysSupply > 0 && daysSupply < low.DaysSupply {
+		fb.LowInventory = low.Standard
+		if tier.Oversize {
+			fb.LowInventory = low.Oversize
+		}
+	}
+
+	fb.Total = fb.Referral + fb.Fulfillment + fb.Storage + fb.LowInventory
+	return fb
+}
+
+// sizeTier finds the first tier the item fits. ok is false when SKU
+// Vault has no weight or dimensions for it.
+func (tbl *feeTable) sizeTier(svd svData) (sizeTier, bool) {
+	if svd.Weight <= 0 || svd.Length <= 0 || svd.Width <= 0 || svd.Height <= 0 {
+		return sizeTier{}, false
+	}
+
+	sides := []float64{svd.Length, svd.Width, svd.Height}
+	sort.Sort(sort.Reverse(sort.Float64Slice(sides)))
+	long, median, short := sides[0], sides[1], sides[2]
+	lenGirth := long + 2*(median+short)
+
+	for _, t := range tbl.SizeTiers {
+		if t.MaxWeightLb > 0 && svd.Weight > t.MaxWeightLb ||
+			t.MaxLongIn > 0 && long > t.MaxLongIn ||
+			t.MaxMedianIn > 0 && median > t.MaxMedianIn ||
+			t.MaxShortIn > 0 && short > t.MaxShortIn ||
+			t.MaxLengthGirthIn > 0 && lenGirth > t.MaxLengthGirthIn {
+			continue
+		}
+		return t, true
+	}
+	return tbl.SizeTiers[len(tbl.SizeTiers)-1], true
+}
+
+// shipWeight is the greater of unit and dimensional weight, plus
+// packaging, rounded up to the pound over one pound.
+func (tbl *feeTable) shipWeight(t sizeTier, svd svData) float64 {
+	w := svd.Weight
+	if t.DimWeight && tbl.DimDivisor > 0 {
+		width := svd.Width
+		if t.Oversize && width < 2 {
+			width = 2
+		}
+		w = math.Max(w, svd.Length*width*svd.Height/tbl.DimDivisor)
+	}
+	w += t.PackagingLb
+	if w > 1 {
+		w = math.Ceil(w)
+	}
+	return w
+}
+
+func (t sizeTier) fee(lb float64) float64 {
+	for _, s := range t.Steps {
+		if lb <= s.UpToLb {
+			return s.Fee
+		}
+	}
+	if t.Over != nil {
+		return t.Over.Fee + math.Max(0, math.Ceil(lb-t.Over.FromLb))*t.Over.PerLb
+	}
+	if n := len(t.Steps); n > 0 {
+		return t.Steps[n-1].Fee
+	}
+	return 0
+}
+
+func (tbl *feeTable) storageRate(t sizeTier, m time.Month) float64 {
+	for _, s := range tbl.Storage {
+		for _, sm := range s.Months {
+			if sm != m {
+				continue
+			}
+			if t.Oversize {
+				return s.Oversize
+			}
+			return s.Standard
+		}
+	}
+	return 0
+}
+
+// poundsFrom converts a SKU Vault weight to pounds.
+func poundsFrom(value, unit string) float64 {
+	w, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0
+	}
+	switch strings.ToLower(strings.TrimSpace(unit)) {
+	case "oz", "ounce", "ounces":
+		return w / 16
+	case "g", "gram", "grams":
+		return w / 453.59237
+	case "kg", "kilogram", "kilograms":
+		return w * 2.20462262
+	}
+	return w
+}
@@ -0,0 +1,201 @@
+package stock
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSizeTier(t *testing.T) {
+	tbl, err := loadFeeTable(defFeeTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		svd     svData
+		want    string
+		unknown bool
+	}{
+		{name: "no weight", svd: svData{Length: 10, Width: 8, Height: 4}, unknown: true},
+		{name: "no height", svd: svData{Weight: 1, Length: 10, Width: 8}, unknown: true},
+		{name: "small standard at the limits", svd: svData{Weight: 1, Length: 15, Width: 12, Height: 0.75}, want: "Small standard"},
+		{name: "sides in any order", svd: svData{Weight: 1, Length: 0.75, Width: 15, Height: 12}, want: "Small standard"},
+		{name: "too thick for small", svd: svData{Weight: 1, Length: 15, Width: 12, Height: 0.76}, want: "Large standard"},
+		{name: "too heavy for small", svd: svData{Weight: 1.01, Length: 15, Width: 12, Height: 0.75}, want: "Large standard"},
+		{name: "large standard at the limits", svd: svData{Weight: 20, Length: 18, Width: 14, Height: 8}, want: "Large standard"},
+		{name: "too long for standard", svd: svData{Weight: 20, Length: 18.1, Width: 14, Height: 8}, want: "Small oversize"},
+		{name: "small oversize at length and girth", svd: svData{Weight: 70, Length: 60, Width: 30, Height: 5}, want: "Small oversize"},
+		{name: "over small length and girth", svd: svData{Weight: 70, Length: 60, Width: 30, Height: 10}, want: "Large oversize"},
+		{name: "too heavy for small oversize", svd: svData{Weight: 71, Length: 10, Width: 10, Height: 10}, want: "Medium oversize"},
+		{name: "too heavy for large oversize", svd: svData{Weight: 151, Length: 10, Width: 10, Height: 10}, want: "Special oversize"},
+		{name: "too long for large oversize", svd: svData{Weight: 10, Length: 109, Width: 5, Height: 5}, want: "Special oversize"},
+	}
+	for _, tt := range tests {
+		tier, ok := tbl.sizeTier(tt.svd)
+		if ok == tt.unknown || ok && tier.Name != tt.want {
+			t.Errorf("%s: sizeTier = %q, %v; want %q", tt.name, tier.Name, ok, tt.want)
+		}
+	}
+}
+
+func TestFulfillmentFee(t *testing.T) {
+	tbl, err := loadFeeTable(defFeeTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		svd  svData
+		ship float64
+		fee  float64
+	}{
+		{"small standard light", svData{Weight: 0.3, Length: 10, Width: 8, Height: 0.5}, 0.55, 2.41},
+		{"small standard", svData{Weight: 0.5, Length: 10, Width: 8, Height: 0.5}, 0.75, 2.48},
+		// 10×8×4 is 2.3lb dimensional weight, over the unit weight.
+		{"large standard by dimensions", svData{Weight: 1.5, Length: 10, Width: 8, Height: 4}, 3, 5.26},
+		{"large standard over 3lb", svData{Weight: 6, Length: 10, Width: 8, Height: 4}, 7, 5.26 + 4*0.38},
+		{"small oversize", svData{Weight: 10, Length: 30, Width: 10, Height: 5}, 12, 8.26 + 10*0.38},
+	}
+	for _, tt := range tests {
+		tier, _ := tbl.sizeTier(tt.svd)
+		ship := tbl.shipWeight(tier, tt.svd)
+		fee := tier.fee(ship)
+		if math.Abs(ship-tt.ship) > 1e-9 || math.Abs(fee-tt.fee) > 1e-9 {
+			t.Errorf("%s: ship %v lb, fee %v; want %v lb, %v", tt.name, ship, fee, tt.ship, tt.fee)
+		}
+	}
+}
+
+func TestStorageRate(t *testing.T) {
+	tbl, err := loadFeeTable(defFeeTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	standard, oversize := sizeTier{}, sizeTier{Oversize: true}
+	tests := []struct {
+		tier  sizeTier
+		month time.Month
+		want  float64
+	}{
+		{standard, time.January, 0.69},
+		{standard, time.September, 0.69},
+		{standard, time.October, 2.40},
+		{standard, time.December, 2.40},
+		{oversize, time.March, 0.48},
+		{oversize, time.November, 1.20},
+	}
+	for _, tt := range tests {
+		if got := tbl.storageRate(tt.tier, tt.month); got != tt.want {
+			t.Errorf("storageRate(oversize %v, %v) = %v, want %v", tt.tier.Oversize, tt.month, got, tt.want)
+		}
+	}
+}
+
+func TestGetFees(t *testing.T) {
+	tbl, err := loadFeeTable(defFeeTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := &rulesFile{}
+	rl.Fees.FeePercentage = 0.1
+	rl.Fees.FBAClass = map[string]float64{"default": 3, "toys": 4}
+	e := &engine{rules: rl, fees: tbl}
+	rule := skuRule{DaysCoverd: 60}
+	boxed := svData{Weight: 1.5, Length: 12, Width: 12, Height: 6}
+	tier, _ := tbl.sizeTier(boxed)
+	// 0.5 cu ft for a month, half the 60 days covered.
+	storage := tbl.storageRate(tier, time.Now().Month()) * 0.5
+
+	tests := []struct {
+		name   string
+		price  float64
+		svd    svData
+		want   feeBreakdown
+		noSize bool
+	}{
+		{
+			name:  "no size uses class fee",
+			price: 20,
+			svd:   svData{Class: "toys"},
+			want:  feeBreakdown{Referral: 2, Fulfillment: 4, Total: 6},
+		},
+		{
+			name:  "no size, unknown class",
+			price: 20,
+			svd:   svData{Class: "games"},
+			want:  feeBreakdown{Referral: 2, Fulfillment: 3, Total: 5},
+		},
+		{
+			name:  "category rate",
+			price: 100,
+			svd:   svData{Category: "Consumer Electronics"},
+			want:  feeBreakdown{Referral: 8, Fulfillment: 3, Total: 11},
+		},
+		{
+			name:  "referral minimum",
+			price: 1,
+			svd:   svData{Category: "Beauty"},
+			want:  feeBreakdown{Referral: 0.3, Fulfillment: 3, Total: 3.3},
+		},
+		{
+			name:  "sized",
+			price: 20,
+			svd:   boxed,
+			// 6.2lb dimensional weight ships as 7lb.
+			want: feeBreakdown{Referral: 2, Fulfillment: 5.26 + 4*0.38, Storage: storage, Total: 2 + 5.26 + 4*0.38 + storage},
+		},
+	}
+	for _, tt := range tests {
+		got := e.getFees(tt.price, tt.svd, 100, rule)
+		for _, f := range []struct {
+			field     string
+			got, want float64
+		}{
+			{"Referral", got.Referral, tt.want.Referral},
+			{"Fulfillment", got.Fulfillment, tt.want.Fulfillment},
+			{"Storage", got.Storage, tt.want.Storage},
+			{"LowInventory", got.LowInventory, 0},
+			{"Total", got.Total, tt.want.Total},
+		} {
+			if math.Abs(f.got-f.want) > 1e-9 {
+				t.Errorf("%s: %s = %v, want %v", tt.name, f.field, f.got, f.want)
+			}
+		}
+		if got.Table != tbl.Version {
+			t.Errorf("%s: Table = %q, want %q", tt.name, got.Table, tbl.Version)
+		}
+	}
+}
+
+func TestGetFeesLowInventory(t *testing.T) {
+	tbl, err := loadFeeTable(defFeeTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.LowInventory.DaysSupply = 28
+	tbl.LowInventory.Standard = 0.32
+	tbl.LowInventory.Oversize = 0.55
+	rl := &rulesFile{}
+	rl.Fees.FBAClass = map[string]float64{"default": 3}
+	e := &engine{rules: rl, fees: tbl}
+
+	standard := svData{Weight: 1, Length: 10, Width: 8, Height: 4}
+	oversize := svData{Weight: 10, Length: 30, Width: 10, Height: 5}
+	tests := []struct {
+		name string
+		svd  svData
+		days float64
+		want float64
+	}{
+		{"plenty", standard, 28, 0},
+		{"low", standard, 27, 0.32},
+		{"low oversize", oversize, 10, 0.55},
+		{"no size", svData{}, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := e.getFees(20, tt.svd, tt.days, skuRule{DaysCoverd: 30}); got.LowInventory != tt.want {
+			t.Errorf("%s: LowInventory = %v, want %v", tt.name, got.LowInventory, tt.want)
+		}
+	}
+}
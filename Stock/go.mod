@@ -9,8 +9,10 @@ require (
 	github.com/extrame/goyymmdd v0.0.0-20181026012948-914eb450555b // indirect
 	github.com/extrame/ole2 v0.0.0-20160812065207-d69429661ad7 // indirect
 	github.com/joho/godotenv v1.3.0
-	github.com/tealeg/xlsx v1.0.3 // indirect
+	github.com/tealeg/xlsx v1.0.3
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/net v0.0.0-20190311183353-d8887717615a // indirect
 	golang.org/x/oauth2 v0.0.0-20190226205417-e64efc72b421 // indirect
+	golang.org/x/sys v0.0.0-20200202164722-d101bd2416d5 // indirect
 	google.golang.org/api v0.1.0
 )
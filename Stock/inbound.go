@@ -0,0 +1,167 @@
+package stock
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prep and labeling owners Amazon accepts in the inbound plan file.
+const (
+	ownerAmazon = "AMAZON"
+	ownerSeller = "SELLER"
+
+	formatInbound = "inbound"
+	mimeInbound   = "text/tab-separated-values"
+)
+
+// inboundRules sets up the inbound shipment plan file, from rules.json
+// Inbound. SKUs overrides the owners and case pack per SKU.
+type inboundRules struct {
+	PlanName      string
+	ShipToCountry string
+	ShipFrom      struct {
+		Name          string
+		AddressLine1  string
+		AddressLine2  string
+		City          string
+		StateOrRegion string
+		PostalCode    string
+		CountryCode   string
+		District      string
+	}
+	PrepOwner     string
+	LabelingOwner string
+	CasePack      int
+	SKUs          map[string]inboundSKU
+}
+
+type inboundSKU struct {
+	PrepOwner     string `json:",omitempty"`
+	LabelingOwner string `json:",omitempty"`
+	CasePack      int    `json:",omitempty"`
+}
+
+// inboundLine is one SKU of the plan.
+type inboundLine struct {
+	SKU           string
+	Quantity      int
+	CasePack      int
+	PrepOwner     string
+	LabelingOwner string
+}
+
+// defaults fills in what rules.json leaves out of Inbound.
+func (in *inboundRules) defaults() {
+	if in.ShipToCountry == "" {
+		in.ShipToCountry = "US"
+	}
+	if in.ShipFrom.CountryCode == "" {
+		in.ShipFrom.CountryCode = "US"
+	}
+	if in.PrepOwner == "" {
+		in.PrepOwner = ownerSeller
+	}
+	if in.LabelingOwner == "" {
+		in.LabelingOwner = ownerSeller
+	}
+}
+
+// check adds a problem for every owner that is not AMAZON or SELLER and
+// every negative case pack.
+func (in *inboundRules) check(probs *rulesErrors) {
+	checkOwner(probs, "Inbound.PrepOwner", in.PrepOwner, false)
+	checkOwner(probs, "Inbound.LabelingOwner", in.LabelingOwner, false)
+	if in.CasePack < 0 {
+		probs.add("Inbound.CasePack", "must be 0 or more, got "+strconv.Itoa(in.CasePack))
+	}
+	for _, sku := range sortedKeys(in.SKUs) {
+		s := in.SKUs[sku]
+		path := `Inbound.SKUs["` + sku + `"].`
+		checkOwner(probs, path+"PrepOwner", s.PrepOwner, true)
+		checkOwner(probs, path+"LabelingOwner", s.LabelingOwner, true)
+		if s.CasePack < 0 {
+			probs.add(path+"CasePack", "must be 0 or more, got "+strconv.Itoa(s.CasePack))
+		}
+	}
+}
+
+func checkOwner(probs *rulesErrors, path, owner string, blank bool) {
+	if owner == ownerAmazon || owner == ownerSeller || blank && owner == "" {
+		return
+	}
+	probs.add(path, `must be "`+ownerAmazon+`" or "`+ownerSeller+`", got "`+owner+`"`)
+}
+
+// inboundPlan turns the suggested SKUs into plan lines. Quantities are
+// rounded down to whole cases; SKUs with less than a case are returned
+// in short.
+func inboundPlan(in inboundRules, suggested map[string]topSellerH) (lines []inboundLine, short []string) {
+	for _, sku := range sortedKeys(suggested) {
+		ln := inboundLine{
+			SKU:           sku,
+			Quantity:      suggested[sku].SugQt,
+			CasePack:      in.CasePack,
+			PrepOwner:     in.PrepOwner,
+			LabelingOwner: in.LabelingOwner,
+		}
+		if s, ok := in.SKUs[sku]; ok {
+			if s.CasePack > 0 {
+				ln.CasePack = s.CasePack
+			}
+			if s.PrepOwner != "" {
+				ln.PrepOwner = s.PrepOwner
+			}
+			if s.LabelingOwner != "" {
+				ln.LabelingOwner = s.LabelingOwner
+			}
+		}
+		if ln.CasePack > 0 {
+			ln.Quantity -= ln.Quantity % ln.CasePack
+		}
+		if ln.Quantity <= 0 {
+			short = append(short, sku)
+			continue
+		}
+		lines = append(lines, ln)
+	}
+	return lines, short
+}
+
+// writeInbound writes Amazon's tab-delimited inbound plan file: the
+// header block with the plan name and ship from address, a blank line,
+// then one row per SKU.
+func writeInbound(in inboundRules, lines []inboundLine) []byte {
+	name := in.PlanName
+	if name == "" {
+		name = "FBA " + time.Now().Format("2006-01-02")
+	}
+
+	buf := bytes.Buffer{}
+	row := func(cells ...string) {
+		buf.WriteString(strings.Join(cells, "\t") + "\r\n")
+	}
+
+	from := in.ShipFrom
+	row("PlanName", name)
+	row("ShipToCountry", in.ShipToCountry)
+	row("AddressName", from.Name)
+	row("AddressFieldOne", from.AddressLine1)
+	row("AddressFieldTwo", from.AddressLine2)
+	row("AddressCity", from.City)
+	row("AddressCountryCode", from.CountryCode)
+	row("AddressStateOrRegion", from.StateOrRegion)
+	row("AddressPostalCode", from.PostalCode)
+	row("AddressDistrict", from.District)
+	row()
+	row("MerchantSKU", "Quantity", "QuantityInCase", "PrepOwner", "LabelingOwner")
+	for _, ln := range lines {
+		inCase := ""
+		if ln.CasePack > 0 {
+			inCase = strconv.Itoa(ln.CasePack)
+		}
+		row(ln.SKU, strconv.Itoa(ln.Quantity), inCase, ln.PrepOwner, ln.LabelingOwner)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,170 @@
+package stock
+
+import "math"
+
+// Pricing strategies set by rules Pricing.Strategy.
+const (
+	// priceProfit earns the SKU's rule Profit in dollars a unit.
+	priceProfit = "profit"
+	// priceMargin earns Pricing.Margin of the selling price.
+	priceMargin = "margin"
+	// priceMAP sells at the brand's minimum advertised price, see
+	// pricingRules MAP.
+	priceMAP = "map"
+
+	defPriceStrategy = priceProfit
+	defBandMin       = 0.8
+	defBandMax       = 1.25
+)
+
+// pricingRules sets up suggest_price, from rules.json Pricing.
+type pricingRules struct {
+	Strategy string
+	// Margin is profit over selling price for the margin strategy.
+	Margin float64
+	// MAP is the minimum advertised price by brand, as a fraction of
+	// each SKU's SKU Vault RetailPrice: 0.9 is 90% of retail. There is
+	// no MAP by SKU, and SKUs with no RetailPrice get no floor. No
+	// suggestion goes under it.
+	MAP map[string]float64
+	// Band keeps suggestions between Min and Max times EstPrice.
+	Band struct {
+		Min float64
+		Max float64
+	}
+}
+
+func (pr *pricingRules) defaults() {
+	if pr.Strategy == "" {
+		pr.Strategy = defPriceStrategy
+	}
+	if pr.Band.Min == 0 {
+		pr.Band.Min = defBandMin
+	}
+	if pr.Band.Max == 0 {
+		pr.Band.Max = defBandMax
+	}
+}
+
+func (pr *pricingRules) check(probs *rulesErrors) {
+	switch pr.Strategy {
+	case priceProfit, priceMargin, priceMAP:
+	default:
+		probs.add("Pricing.Strategy", `must be "`+priceProfit+`", "`+priceMargin+`" or "`+priceMAP+`", got "`+pr.Strategy+`"`)
+	}
+	if pr.Margin < 0 || pr.Margin >= 1 {
+		probs.add("Pricing.Margin", "must be from 0 up to 1, got "+ftoa(pr.Margin))
+	}
+	if pr.Band.Min <= 0 || pr.Band.Min > 1 {
+		probs.add("Pricing.Band.Min", "must be more than 0 up to 1, got "+ftoa(pr.Band.Min))
+	}
+	if pr.Band.Max < 1 {
+		probs.add("Pricing.Band.Max", "must be 1 or more, got "+ftoa(pr.Band.Max))
+	}
+	for _, brand := range sortedKeys(pr.MAP) {
+		if m := pr.MAP[brand]; m <= 0 {
+			probs.add(`Pricing.MAP["`+brand+`"]`, "must be more than 0, got "+ftoa(m))
+		}
+	}
+}
+
+// Why a suggested price is what it is.
+const (
+	basisTarget  = "target"
+	basisBandMin = "band_min"
+	basisBandMax = "band_max"
+	basisMAP     = "map"
+	// basisNoSolution is a profit or margin target no price meets;
+	// Price is left 0.
+	basisNoSolution = "no_solution"
+)
+
+// priceSuggestion is a suggested price with the fees and profit at it.
+type priceSuggestion struct {
+	Price  float64
+	Fees   feeBreakdown
+	Prof   float64
+	Basis  string
+	Target float64
+}
+
+// suggestPrice prices svd for the rules Pricing strategy.
+//
+// The profit and margin strategies solve for the lowest price where
+// price - cost - fees(price) meets the target, recomputing fees at each
+// candidate as the referral fee grows with price. The result is held
+// to the band around estPrice when the SKU has sold, then raised to the
+// brand's MAP, which always wins. When no price meets the target the
+// suggestion has no price and basis no_solution.
+func (e *engine) suggestPrice(svd svData, estPrice, daysSupply float64, rule skuRule) priceSuggestion {
+	pr := e.rules.Pricing
+	ps := priceSuggestion{Basis: basisTarget}
+
+	floor := 0.0
+	if m, ok := pr.MAP[svd.Brand]; ok {
+		floor = math.Ceil(svd.RetailPrice*m*100) / 100
+	}
+
+	ok := true
+	switch pr.Strategy {
+	case priceMAP:
+		ps.Target = floor
+		if floor == 0 {
+			ps.Target = estPrice
+		}
+	case priceMargin:
+		ps.Target, ok = e.solvePrice(svd, daysSupply, rule, func(p float64) float64 { return p * pr.Margin })
+	default:
+		ps.Target, ok = e.solvePrice(svd, daysSupply, rule, func(float64) float64 { return rule.Profit })
+	}
+	if !ok {
+		ps.Basis = basisNoSolution
+		return ps
+	}
+	ps.Price = ps.Target
+
+	if estPrice > 0 {
+		lo, hi := estPrice*pr.Band.Min, estPrice*pr.Band.Max
+		switch {
+		case ps.Price < lo:
+			ps.Price, ps.Basis = lo, basisBandMin
+		case ps.Price > hi:
+			ps.Price, ps.Basis = hi, basisBandMax
+		}
+	}
+	if ps.Price < floor {
+		ps.Price, ps.Basis = floor, basisMAP
+	}
+	ps.Price = math.Round(ps.Price*100) / 100
+
+	ps.Fees = e.getFees(ps.Price, svd, daysSupply, rule)
+	ps.Prof = ps.Price - svd.Cost - ps.Fees.Total
+	return ps
+}
+
+// solvePrice finds the lowest price, to the cent, where the profit after
+// fees is at least target(price). While the referral rate and margin
+// stay under 100% the profit gap only changes sign once, so bisection
+// finds it; ok is false when there is no such price.
+func (e *engine) solvePrice(svd svData, daysSupply float64, rule skuRule, target func(float64) float64) (price float64, ok bool) {
+	gap := func(p float64) float64 {
+		return p - svd.Cost - e.getFees(p, svd, daysSupply, rule).Total - target(p)
+	}
+
+	lo, hi := 0.0, math.Max(svd.Cost, 1)
+	for gap(hi) < 0 {
+		lo, hi = hi, hi*2
+		if hi > 1e6 {
+			return 0, false
+		}
+	}
+	for hi-lo > 0.005 {
+		mid := (lo + hi) / 2
+		if gap(mid) < 0 {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return math.Ceil(hi*100) / 100, true
+}
@@ -0,0 +1,158 @@
+package stock
+
+import (
+	"math"
+	"testing"
+)
+
+// pricingEngine prices SKUs with no size, so fees are 10% referral,
+// 30¢ at least, plus a $3 class fee.
+func pricingEngine(t *testing.T, pr pricingRules) *engine {
+	t.Helper()
+	tbl, err := loadFeeTable(defFeeTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := &rulesFile{Pricing: pr}
+	rl.Pricing.defaults()
+	rl.Fees.FeePercentage = 0.1
+	rl.Fees.FBAClass = map[string]float64{"default": 3}
+	return &engine{rules: rl, fees: tbl}
+}
+
+func TestSuggestPrice(t *testing.T) {
+	mapAcme := map[string]float64{"Acme": 0.9}
+	tests := []struct {
+		name     string
+		pr       pricingRules
+		svd      svData
+		estPrice float64
+		profit   float64
+		want     priceSuggestion
+	}{
+		{
+			name:   "profit target",
+			svd:    svData{Cost: 10},
+			profit: 5,
+			want:   priceSuggestion{Price: 20, Prof: 5, Basis: basisTarget, Target: 20},
+		},
+		{
+			// 0.9p = 18.05 is 20.0555…, up to the next cent.
+			name:   "profit target to the cent",
+			svd:    svData{Cost: 10},
+			profit: 5.05,
+			want:   priceSuggestion{Price: 20.06, Prof: 5.054, Basis: basisTarget, Target: 20.06},
+		},
+		{
+			name:     "held to band max",
+			svd:      svData{Cost: 10},
+			estPrice: 15,
+			profit:   5,
+			want:     priceSuggestion{Price: 18.75, Prof: 3.875, Basis: basisBandMax, Target: 20},
+		},
+		{
+			name:     "held to band min",
+			svd:      svData{Cost: 1},
+			estPrice: 10,
+			profit:   1,
+			want:     priceSuggestion{Price: 8, Prof: 3.2, Basis: basisBandMin, Target: 5.56},
+		},
+		{
+			name: "margin target",
+			pr:   pricingRules{Strategy: priceMargin, Margin: 0.2},
+			svd:  svData{Cost: 10},
+			// 0.7p = 13.
+			want: priceSuggestion{Price: 18.58, Prof: 3.722, Basis: basisTarget, Target: 18.58},
+		},
+		{
+			name:     "MAP over band max",
+			pr:       pricingRules{MAP: mapAcme},
+			svd:      svData{Cost: 10, Brand: "Acme", RetailPrice: 30},
+			estPrice: 15,
+			profit:   5,
+			want:     priceSuggestion{Price: 27, Prof: 11.3, Basis: basisMAP, Target: 20},
+		},
+		{
+			name:   "MAP of another brand",
+			pr:     pricingRules{MAP: mapAcme},
+			svd:    svData{Cost: 10, Brand: "Other", RetailPrice: 30},
+			profit: 5,
+			want:   priceSuggestion{Price: 20, Prof: 5, Basis: basisTarget, Target: 20},
+		},
+		{
+			name:     "map strategy",
+			pr:       pricingRules{Strategy: priceMAP, MAP: mapAcme},
+			svd:      svData{Cost: 10, Brand: "Acme", RetailPrice: 30},
+			estPrice: 25,
+			want:     priceSuggestion{Price: 27, Prof: 11.3, Basis: basisTarget, Target: 27},
+		},
+		{
+			name:     "map strategy with no MAP",
+			pr:       pricingRules{Strategy: priceMAP},
+			svd:      svData{Cost: 10, Brand: "Acme", RetailPrice: 30},
+			estPrice: 25,
+			want:     priceSuggestion{Price: 25, Prof: 9.5, Basis: basisTarget, Target: 25},
+		},
+		{
+			// fees and margin take 105% of any price.
+			name:     "no solution",
+			pr:       pricingRules{Strategy: priceMargin, Margin: 0.95},
+			svd:      svData{Cost: 10},
+			estPrice: 25,
+			want:     priceSuggestion{Basis: basisNoSolution},
+		},
+	}
+	for _, tt := range tests {
+		e := pricingEngine(t, tt.pr)
+		got := e.suggestPrice(tt.svd, tt.estPrice, 30, skuRule{Profit: tt.profit, DaysCoverd: 30})
+		if got.Basis != tt.want.Basis || got.Price != tt.want.Price || got.Target != tt.want.Target || math.Abs(got.Prof-tt.want.Prof) > 1e-9 {
+			t.Errorf("%s: got %s %v (target %v, profit %v), want %s %v (target %v, profit %v)",
+				tt.name, got.Basis, got.Price, got.Target, got.Prof, tt.want.Basis, tt.want.Price, tt.want.Target, tt.want.Prof)
+		}
+		if got.Basis != basisNoSolution && math.Abs(got.Price-tt.svd.Cost-got.Fees.Total-got.Prof) > 1e-9 {
+			t.Errorf("%s: profit %v is not price less cost and fees %+v", tt.name, got.Prof, got.Fees)
+		}
+	}
+}
+
+func TestSolvePrice(t *testing.T) {
+	e := pricingEngine(t, pricingRules{})
+	rule := skuRule{DaysCoverd: 30}
+	profit := func(svd svData, p float64) float64 {
+		return p - svd.Cost - e.getFees(p, svd, 30, rule).Total
+	}
+
+	tests := []struct {
+		name   string
+		svd    svData
+		target func(float64) float64
+		ok     bool
+	}{
+		{"no cost", svData{}, func(float64) float64 { return 0 }, true},
+		{"under the referral minimum", svData{Cost: 0.5}, func(float64) float64 { return 0.1 }, true},
+		{"dollar profit", svData{Cost: 12.34}, func(float64) float64 { return 4.56 }, true},
+		{"past the first guess", svData{Cost: 0.1}, func(float64) float64 { return 500 }, true},
+		{"margin", svData{Cost: 7}, func(p float64) float64 { return p * 0.33 }, true},
+		{"margin of all of it", svData{Cost: 7}, func(p float64) float64 { return p * 0.9 }, false},
+	}
+	for _, tt := range tests {
+		p, ok := e.solvePrice(tt.svd, 30, rule, tt.target)
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if p != math.Round(p*100)/100 {
+			t.Errorf("%s: price %v is not whole cents", tt.name, p)
+		}
+		// the lowest cent that meets the target.
+		if profit(tt.svd, p) < tt.target(p) {
+			t.Errorf("%s: %v earns %v, under target %v", tt.name, p, profit(tt.svd, p), tt.target(p))
+		}
+		if below := p - 0.01; below > 0 && profit(tt.svd, below) >= tt.target(below) {
+			t.Errorf("%s: %v meets the target, under %v", tt.name, below, p)
+		}
+	}
+}
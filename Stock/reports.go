@@ -0,0 +1,381 @@
+package stock
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WedgeNix/excel"
+)
+
+// reportUsage tells the caller which files a run used and which it skipped.
+type reportUsage struct {
+	Used    []usedFile
+	Ignored []ignoredFile
+}
+
+type usedFile struct {
+	Name string
+	Type string
+}
+
+type ignoredFile struct {
+	Name   string
+	Reason string
+}
+
+// sameAge is the error for two files where only the newest is used
+// but neither is newer.
+func sameAge(kind string, a, b reportFile) error {
+	return errors.New("getReports: " + a.Name + " and " + b.Name + " are both " + kind + " of the same time; send only one")
+}
+
+// parsedReport is a CSV report with its detected type.
+type parsedReport struct {
+	file reportFile
+	kind reportKind
+	rows [][]string
+}
+
+// getReports reads the reports and rules from src and maps them
+// for getSuggestion.
+//
+// Any number of files is accepted. The newest rules file and Restock
+// Report are used; two of the same time, as uploads are, is an error.
+// CA data and Business Reports are merged so several date ranges can
+// be combined. Files that are not needed are listed in Files.Ignored
+// with the reason.
+func getReports(src reportSource) (*fbaStockFiles, error) {
+	filz, err := src.files()
+	if err != nil {
+		return nil, err
+	}
+
+	// newest first so the first file of each type wins.
+	sort.SliceStable(filz, func(i, j int) bool {
+		return filz[i].Modified.After(filz[j].Modified)
+	})
+
+	stock := &fbaStockFiles{}
+	use := &stock.Files
+
+	var rulesFound bool
+	var rulesFile reportFile
+	reports := map[reportKind][]parsedReport{}
+	seen := map[[sha1.Size]byte]string{}
+	for _, file := range filz {
+		switch strings.ToLower(filepath.Ext(file.Name)) {
+		case ".json":
+			if rulesFound {
+				if file.Modified.Equal(rulesFile.Modified) {
+					return nil, sameAge("rules files", rulesFile, file)
+				}
+				use.ignore(file.Name, "older rules file")
+				continue
+			}
+			stock.rules, err = getSettings(file)
+			if err != nil {
+				return nil, err
+			}
+			rulesFound = true
+			rulesFile = file
+			use.use(file.Name, "rules")
+			continue
+		case ".csv":
+		default:
+			use.ignore(file.Name, "not a csv report or json rules file")
+			continue
+		}
+
+		csvData, err := getData(file)
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha1.Sum([]byte(fmt.Sprint(csvData)))
+		if dup, ok := seen[sum]; ok {
+			use.ignore(file.Name, "same data as "+dup)
+			continue
+		}
+		seen[sum] = file.Name
+
+		kind, err := detectReport(file.Name, csvData)
+		if err != nil {
+			use.ignore(file.Name, err.Error())
+			continue
+		}
+
+		if kind == restockReport && len(reports[kind]) > 0 {
+			if newest := reports[kind][0].file; file.Modified.Equal(newest.Modified) {
+				return nil, sameAge(kind.String()+"s", newest, file)
+			}
+			use.ignore(file.Name, "older "+kind.String())
+			continue
+		}
+		reports[kind] = append(reports[kind], parsedReport{file, kind, csvData})
+	}
+
+	missing := []string{}
+	if !rulesFound {
+		missing = append(missing, "rules")
+	}
+	for _, k := range []reportKind{caReport, restockReport, viewsReport} {
+		if len(reports[k]) == 0 {
+			missing = append(missing, k.String())
+		}
+	}
+	if len(missing) > 0 {
+		msg := `getReports: missing ` + strings.Join(missing, ", ")
+		for _, ig := range use.Ignored {
+			msg += `; ignored ` + ig.Name + `: ` + ig.Reason
+		}
+		return nil, errors.New(msg)
+	}
+
+	var tops []top
+	for _, rep := range reports[caReport] {
+		var t []top
+		if err := unmarshalReport(rep, &t); err != nil {
+			return nil, err
+		}
+		tops = append(tops, t...)
+		stock.useReport(rep)
+	}
+	merged, periods, covered := mergeTops(tops)
+	taken := 0
+	for _, ps := range periods {
+		taken += len(ps)
+	}
+	if dropped := len(tops) - taken; dropped > 0 {
+		logP(strconv.Itoa(dropped) + " CA rows dropped for overlapping a newer date range of the same SKU")
+	}
+	stock.mapDataCA(merged)
+	stock.periods = periods
+	stock.caDays = covered
+
+	var fbar []fbaRestockF
+	rep := reports[restockReport][0]
+	rep.rows = fillOptional(restockReserved(rep.rows), fbaRestockF{})
+	if err := unmarshalReport(rep, &fbar); err != nil {
+		return nil, err
+	}
+	stock.useReport(rep)
+	stock.mapDataRestock(fbar)
+
+	var amzv []amzViewsH
+	for _, rep := range pickViews(reports[viewsReport], use) {
+		var v []amzViewsH
+		if err := unmarshalReport(rep, &v); err != nil {
+			return nil, err
+		}
+		amzv = append(amzv, v...)
+		stock.useReport(rep)
+	}
+	stock.mapDataView(mergeViews(amzv))
+
+	return stock, nil
+}
+
+// useReport notes rep as used and keeps its rows for the snapshot.
+// Only reports go in used for consumeSource; the rules file stays in
+// the source for the next run.
+func (filz *fbaStockFiles) useReport(rep parsedReport) {
+	filz.Files.use(rep.file.Name, rep.kind.String())
+	filz.used = append(filz.used, rep.file)
+	if filz.inputs == nil {
+		filz.inputs = make(map[string][][]string)
+	}
+	filz.inputs[rep.file.Name] = rep.rows
+}
+
+func (u *reportUsage) use(name, typ string) {
+	u.Used = append(u.Used, usedFile{name, typ})
+}
+
+func (u *reportUsage) ignore(name, reason string) {
+	u.Ignored = append(u.Ignored, ignoredFile{name, reason})
+}
+
+func unmarshalReport(rep parsedReport, ptr interface{}) error {
+	f := excel.File{
+		Sheets: [][][]string{rep.rows},
+	}
+	if err := f.Unmarshal(ptr); err != nil {
+		return errors.New(rep.file.Name + `: ` + err.Error())
+	}
+	return nil
+}
+
+// mergeTops combines CA rows of the same SKU from several date ranges.
+// Rows whose date range overlaps one already taken for the SKU are
+// dropped so no day is counted twice; the newest file is read first so
+// its numbers are kept. The sales of each range are returned for the
+// daily spread, and the days they cover, gaps left out, for dailySales.
+func mergeTops(data []top) ([]top, map[string][]salesPeriod, map[string]float64) {
+	periods := map[string][]salesPeriod{}
+	covered := map[string]float64{}
+	merged := map[string]top{}
+	order := []string{}
+	for _, row := range data {
+		sp := dateSpan{row.ReportStartDate, row.ReportEndDate}
+		if overlapsPeriod(sp, periods[row.SKU]) {
+			continue
+		}
+		days := sp.days()
+		periods[row.SKU] = append(periods[row.SKU], salesPeriod{row.ReportStartDate, days, row.QtySold})
+		covered[row.SKU] += days
+
+		m, ok := merged[row.SKU]
+		if !ok {
+			merged[row.SKU] = row
+			order = append(order, row.SKU)
+			continue
+		}
+		m.QtySold += row.QtySold
+		m.GMV += row.GMV
+		if row.ReportStartDate.Before(m.ReportStartDate) {
+			m.ReportStartDate = row.ReportStartDate
+		}
+		if row.ReportEndDate.After(m.ReportEndDate) {
+			m.ReportEndDate = row.ReportEndDate
+		}
+		merged[row.SKU] = m
+	}
+
+	tops := make([]top, 0, len(order))
+	for _, sku := range order {
+		tops = append(tops, merged[sku])
+	}
+	return tops, periods, covered
+}
+
+// dateSpan is a report date range, end not included.
+type dateSpan struct {
+	start, end time.Time
+}
+
+func (ds dateSpan) days() float64 {
+	return ds.end.Sub(ds.start).Hours() / 24
+}
+
+func (ds dateSpan) overlaps(o dateSpan) bool {
+	return ds.start.Before(o.end) && o.start.Before(ds.end)
+}
+
+// overlapsPeriod tells if sp shares any day with one of periods.
+func overlapsPeriod(sp dateSpan, periods []salesPeriod) bool {
+	for _, p := range periods {
+		end := p.start.Add(time.Duration(p.days * 24 * float64(time.Hour)))
+		if sp.overlaps(dateSpan{p.start, end}) || sp.start.Equal(p.start) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	nameDateEx = regexp.MustCompile(`\d{4}-\d{1,2}-\d{1,2}|\d{1,2}-\d{1,2}-\d{2,4}`)
+	// nameDateLayouts are the date forms nameDateEx finds.
+	nameDateLayouts = []string{"2006-1-2", "1-2-2006", "1-2-06"}
+)
+
+// viewsSpan reads a Business Report's date range from its file name,
+// as the first and last day of two dates in it. Business Report rows
+// have no dates, so reports without them can't be merged.
+func viewsSpan(name string) (dateSpan, bool) {
+	found := nameDateEx.FindAllString(name, -1)
+	if len(found) != 2 {
+		return dateSpan{}, false
+	}
+	dates := []time.Time{}
+	for _, s := range found {
+		for _, layout := range nameDateLayouts {
+			if d, err := time.Parse(layout, s); err == nil {
+				dates = append(dates, d)
+				break
+			}
+		}
+	}
+	if len(dates) != 2 || dates[1].Before(dates[0]) {
+		return dateSpan{}, false
+	}
+	return dateSpan{dates[0], dates[1].AddDate(0, 0, 1)}, true
+}
+
+// pickViews picks the Business Reports to merge, newest first. Only
+// reports with a date range in their name are merged, and those that
+// overlap one already picked are left out. If the newest has no range
+// it is used alone.
+func pickViews(reps []parsedReport, use *reportUsage) []parsedReport {
+	first, ok := viewsSpan(reps[0].file.Name)
+	if !ok {
+		for _, rep := range reps[1:] {
+			use.ignore(rep.file.Name, "newer "+viewsReport.String()+" "+reps[0].file.Name+" has no date range in its name to merge by")
+		}
+		return reps[:1]
+	}
+
+	picked := reps[:1]
+	spans := []dateSpan{first}
+	for _, rep := range reps[1:] {
+		sp, ok := viewsSpan(rep.file.Name)
+		if !ok {
+			use.ignore(rep.file.Name, "no date range in its name to merge by")
+			continue
+		}
+		overlap := ""
+		for i, o := range spans {
+			if sp.overlaps(o) {
+				overlap = picked[i].file.Name
+				break
+			}
+		}
+		if overlap != "" {
+			use.ignore(rep.file.Name, "date range overlaps "+overlap)
+			continue
+		}
+		picked = append(picked, rep)
+		spans = append(spans, sp)
+	}
+	return picked
+}
+
+// mergeViews sums Business Report rows of the same SKU, from reports
+// picked by pickViews so no day is counted twice. The percentages are
+// weighted by page views and sessions.
+func mergeViews(data []amzViewsH) []amzViewsH {
+	merged := map[string]amzViewsH{}
+	order := []string{}
+	for _, row := range data {
+		m, ok := merged[row.SKU]
+		if !ok {
+			merged[row.SKU] = row
+			order = append(order, row.SKU)
+			continue
+		}
+		pv := m.PageViews + row.PageViews
+		if pv > 0 {
+			m.BuyBoxPercentage = (m.BuyBoxPercentage*float64(m.PageViews) + row.BuyBoxPercentage*float64(row.PageViews)) / float64(pv)
+		}
+		m.Sessions += row.Sessions
+		m.PageViews = pv
+		m.UnitsOrdered += row.UnitsOrdered
+		m.OrdProdSales += row.OrdProdSales
+		if m.Sessions > 0 {
+			m.UnitSessionPercentage = float64(m.UnitsOrdered) / float64(m.Sessions)
+		}
+		merged[row.SKU] = m
+	}
+
+	views := make([]amzViewsH, 0, len(order))
+	for _, sku := range order {
+		views = append(views, merged[sku])
+	}
+	return views
+}
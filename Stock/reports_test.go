@@ -0,0 +1,216 @@
+package stock
+
+import (
+	"testing"
+	"time"
+)
+
+func date(month time.Month, d int) time.Time {
+	return time.Date(2018, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestMergeTops(t *testing.T) {
+	row := func(sku string, start, end time.Time, qty int) top {
+		return top{SKU: sku, ReportStartDate: start, ReportEndDate: end, QtySold: qty, GMV: float64(qty) * 10}
+	}
+	tests := []struct {
+		name string
+		// data is newest file first, as getReports reads them.
+		data    []top
+		qty     map[string]int
+		covered map[string]float64
+		periods map[string]int
+	}{
+		{
+			name:    "one range",
+			data:    []top{row("A", date(1, 1), date(2, 1), 31)},
+			qty:     map[string]int{"A": 31},
+			covered: map[string]float64{"A": 31},
+			periods: map[string]int{"A": 1},
+		},
+		{
+			name:    "back to back",
+			data:    []top{row("A", date(2, 1), date(3, 1), 28), row("A", date(1, 1), date(2, 1), 31)},
+			qty:     map[string]int{"A": 59},
+			covered: map[string]float64{"A": 59},
+			periods: map[string]int{"A": 2},
+		},
+		{
+			name:    "gap left out",
+			data:    []top{row("A", date(3, 1), date(4, 1), 31), row("A", date(1, 1), date(2, 1), 31)},
+			qty:     map[string]int{"A": 62},
+			covered: map[string]float64{"A": 62},
+			periods: map[string]int{"A": 2},
+		},
+		{
+			name:    "overlap keeps newest",
+			data:    []top{row("A", date(1, 15), date(2, 15), 10), row("A", date(1, 1), date(2, 1), 31)},
+			qty:     map[string]int{"A": 10},
+			covered: map[string]float64{"A": 31},
+			periods: map[string]int{"A": 1},
+		},
+		{
+			name:    "same range twice",
+			data:    []top{row("A", date(1, 1), date(2, 1), 31), row("A", date(1, 1), date(2, 1), 31)},
+			qty:     map[string]int{"A": 31},
+			covered: map[string]float64{"A": 31},
+			periods: map[string]int{"A": 1},
+		},
+		{
+			name:    "SKUs apart",
+			data:    []top{row("A", date(1, 1), date(2, 1), 5), row("B", date(1, 15), date(2, 15), 7)},
+			qty:     map[string]int{"A": 5, "B": 7},
+			covered: map[string]float64{"A": 31, "B": 31},
+			periods: map[string]int{"A": 1, "B": 1},
+		},
+	}
+	for _, tt := range tests {
+		tops, periods, covered := mergeTops(tt.data)
+		if len(tops) != len(tt.qty) {
+			t.Errorf("%s: %d SKUs, want %d", tt.name, len(tops), len(tt.qty))
+			continue
+		}
+		for _, m := range tops {
+			if m.QtySold != tt.qty[m.SKU] {
+				t.Errorf("%s: %s QtySold = %d, want %d", tt.name, m.SKU, m.QtySold, tt.qty[m.SKU])
+			}
+			if m.GMV != float64(tt.qty[m.SKU])*10 {
+				t.Errorf("%s: %s GMV = %v, want %v", tt.name, m.SKU, m.GMV, tt.qty[m.SKU]*10)
+			}
+			if covered[m.SKU] != tt.covered[m.SKU] {
+				t.Errorf("%s: %s covered = %v days, want %v", tt.name, m.SKU, covered[m.SKU], tt.covered[m.SKU])
+			}
+			if len(periods[m.SKU]) != tt.periods[m.SKU] {
+				t.Errorf("%s: %s has %d periods, want %d", tt.name, m.SKU, len(periods[m.SKU]), tt.periods[m.SKU])
+			}
+		}
+	}
+}
+
+func TestViewsSpan(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end time.Time
+		ok         bool
+	}{
+		{"BusinessReport-2018-01-01-2018-01-31.csv", date(1, 1), date(2, 1), true},
+		{"BusinessReport_1-1-18_1-31-18.csv", date(1, 1), date(2, 1), true},
+		{"Business Report 02-01-2018 to 02-28-2018.csv", date(2, 1), date(3, 1), true},
+		{"BusinessReport-1-31-18.csv", time.Time{}, time.Time{}, false},
+		{"BusinessReport-2018-02-01-2018-01-01.csv", time.Time{}, time.Time{}, false},
+		{"Business Report.csv", time.Time{}, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		sp, ok := viewsSpan(tt.name)
+		if ok != tt.ok || ok && (!sp.start.Equal(tt.start) || !sp.end.Equal(tt.end)) {
+			t.Errorf("viewsSpan(%q) = %v %v, %v; want %v %v, %v", tt.name, sp.start, sp.end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestPickViews(t *testing.T) {
+	reps := func(names ...string) []parsedReport {
+		out := []parsedReport{}
+		for _, n := range names {
+			out = append(out, parsedReport{file: reportFile{Name: n}, kind: viewsReport})
+		}
+		return out
+	}
+	tests := []struct {
+		name    string
+		reps    []parsedReport
+		picked  []string
+		ignored int
+	}{
+		{
+			name:   "one",
+			reps:   reps("Business Report.csv"),
+			picked: []string{"Business Report.csv"},
+		},
+		{
+			name:    "newest has no range",
+			reps:    reps("Business Report.csv", "BR 2018-01-01 2018-01-31.csv"),
+			picked:  []string{"Business Report.csv"},
+			ignored: 1,
+		},
+		{
+			name:   "back to back",
+			reps:   reps("BR 2018-02-01 2018-02-28.csv", "BR 2018-01-01 2018-01-31.csv"),
+			picked: []string{"BR 2018-02-01 2018-02-28.csv", "BR 2018-01-01 2018-01-31.csv"},
+		},
+		{
+			name:    "overlap",
+			reps:    reps("BR 2018-01-15 2018-02-14.csv", "BR 2018-01-01 2018-01-31.csv", "BR 2017-12-01 2017-12-31.csv"),
+			picked:  []string{"BR 2018-01-15 2018-02-14.csv", "BR 2017-12-01 2017-12-31.csv"},
+			ignored: 1,
+		},
+		{
+			name:    "older has no range",
+			reps:    reps("BR 2018-01-01 2018-01-31.csv", "Business Report.csv"),
+			picked:  []string{"BR 2018-01-01 2018-01-31.csv"},
+			ignored: 1,
+		},
+	}
+	for _, tt := range tests {
+		use := &reportUsage{}
+		picked := pickViews(tt.reps, use)
+		names := []string{}
+		for _, p := range picked {
+			names = append(names, p.file.Name)
+		}
+		if len(names) != len(tt.picked) {
+			t.Errorf("%s: picked %v, want %v", tt.name, names, tt.picked)
+			continue
+		}
+		for i := range names {
+			if names[i] != tt.picked[i] {
+				t.Errorf("%s: picked %v, want %v", tt.name, names, tt.picked)
+				break
+			}
+		}
+		if len(use.Ignored) != tt.ignored {
+			t.Errorf("%s: ignored %+v, want %d", tt.name, use.Ignored, tt.ignored)
+		}
+	}
+}
+
+func TestMergeViews(t *testing.T) {
+	got := mergeViews([]amzViewsH{
+		{SKU: "A", Sessions: 10, PageViews: 20, BuyBoxPercentage: 100, UnitsOrdered: 2, OrdProdSales: 20},
+		{SKU: "A", Sessions: 30, PageViews: 60, BuyBoxPercentage: 50, UnitsOrdered: 6, OrdProdSales: 60},
+		{SKU: "B", Sessions: 5, PageViews: 5, BuyBoxPercentage: 90, UnitsOrdered: 1, OrdProdSales: 9},
+	})
+	if len(got) != 2 {
+		t.Fatalf("mergeViews = %+v, want A and B", got)
+	}
+	a := got[0]
+	if a.Sessions != 40 || a.PageViews != 80 || a.UnitsOrdered != 8 || a.OrdProdSales != 80 {
+		t.Errorf("A = %+v, want the sums", a)
+	}
+	if a.BuyBoxPercentage != 62.5 {
+		t.Errorf("A BuyBoxPercentage = %v, want 62.5 weighted by page views", a.BuyBoxPercentage)
+	}
+	if a.UnitSessionPercentage != 0.2 {
+		t.Errorf("A UnitSessionPercentage = %v, want 0.2", a.UnitSessionPercentage)
+	}
+	if got[1] != (amzViewsH{SKU: "B", Sessions: 5, PageViews: 5, BuyBoxPercentage: 90, UnitsOrdered: 1, OrdProdSales: 9}) {
+		t.Errorf("B = %+v, want it unchanged", got[1])
+	}
+}
+
+func TestDailySales(t *testing.T) {
+	filz := &fbaStockFiles{
+		CAData: map[string]topSellerH{
+			"A": {top: top{QtySold: 62, ReportStartDate: date(1, 1), ReportEndDate: date(4, 1)}},
+			"B": {top: top{QtySold: 31, ReportStartDate: date(1, 1), ReportEndDate: date(2, 1)}},
+		},
+		// A's CA data has a gap in February.
+		caDays: map[string]float64{"A": 62},
+	}
+	if got := filz.dailySales("A"); got != 1 {
+		t.Errorf("dailySales A = %v, want 1 over the covered days", got)
+	}
+	if got := filz.dailySales("B"); got != 1 {
+		t.Errorf("dailySales B = %v, want 1 over the date range", got)
+	}
+}
@@ -0,0 +1,93 @@
+package stock
+
+import "math"
+
+// parentRollup is the parent ASIN totals a child SKU's demand came from.
+type parentRollup struct {
+	ASIN         string
+	Sessions     int
+	UnitsOrdered int
+	OrdProdSales float64
+	// QtySold is the CA units of every child of the parent.
+	QtySold int
+	// Share is the child's part of the parent's units.
+	Share float64
+}
+
+// rollupParents pools the Business Report and CA data of each parent
+// ASIN and gives every child a share of the parent's sales.
+//
+// A child's share is its units ordered over the parent's, with one unit
+// of additive smoothing so variants with no sales in the period still
+// get a little demand. Children then take QtySold = parent QtySold ×
+// share and the parent's date range and covered days, and are judged
+// against Topseller on the parent's total. Children are every SKU the
+// Business Report lists under the parent, sessions or not. SKUs
+// without a parent are left alone.
+func (filz *fbaStockFiles) rollupParents() {
+	children := map[string][]string{}
+	for sku, parent := range filz.parentOf {
+		children[parent] = append(children[parent], sku)
+	}
+
+	filz.parents = make(map[string]parentRollup)
+	if filz.caDays == nil {
+		filz.caDays = make(map[string]float64)
+	}
+	for parent, skus := range children {
+		pr := parentRollup{ASIN: parent}
+		var first, last top
+		var days float64
+		for _, sku := range skus {
+			vu := filz.AMZViews[sku]
+			pr.Sessions += vu.Sessions
+			pr.UnitsOrdered += vu.UnitsOrdered
+			pr.OrdProdSales += vu.OrdProdSales
+
+			row, ok := filz.caRows[sku]
+			if !ok {
+				continue
+			}
+			pr.QtySold += row.QtySold
+			days = math.Max(days, filz.caDays[sku])
+			if first.ReportStartDate.IsZero() || row.ReportStartDate.Before(first.ReportStartDate) {
+				first = row
+			}
+			if row.ReportEndDate.After(last.ReportEndDate) {
+				last = row
+			}
+		}
+		if pr.QtySold == 0 {
+			continue
+		}
+
+		for _, sku := range skus {
+			vu := filz.AMZViews[sku]
+			cpr := pr
+			cpr.Share = (float64(vu.UnitsOrdered) + 1) / (float64(pr.UnitsOrdered) + float64(len(skus)))
+			filz.parents[sku] = cpr
+
+			row := filz.caRows[sku]
+			row.SKU = sku
+			row.QtySold = int(math.Round(float64(pr.QtySold) * cpr.Share))
+			row.ReportStartDate = first.ReportStartDate
+			row.ReportEndDate = last.ReportEndDate
+
+			t := filz.CAData[sku]
+			t.top = row
+			filz.CAData[sku] = t
+			if days > 0 {
+				filz.caDays[sku] = days
+			}
+		}
+	}
+}
+
+// price is the parent's average selling price, used for children
+// with no sales of their own.
+func (pr parentRollup) price() float64 {
+	if pr.UnitsOrdered == 0 {
+		return 0
+	}
+	return pr.OrdProdSales / float64(pr.UnitsOrdered)
+}
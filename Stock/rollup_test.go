@@ -0,0 +1,82 @@
+package stock
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunParentRollup(t *testing.T) {
+	reports := &fbaStockFiles{}
+	reports.mapDataCA([]top{
+		{SKU: "A", QtySold: 2, ReportStartDate: date(1, 1), ReportEndDate: date(1, 31)},
+		{SKU: "B", QtySold: 1, ReportStartDate: date(1, 1), ReportEndDate: date(1, 31)},
+		{SKU: "LONE", QtySold: 2, ReportStartDate: date(1, 1), ReportEndDate: date(1, 31)},
+	})
+	reports.mapDataView([]amzViewsH{
+		{Parent: "P", Child: "CA", SKU: "A", Sessions: 10, UnitsOrdered: 2, OrdProdSales: 40},
+		// B had no sessions, so is not in AMZViews, but is still P's.
+		{Parent: "P", Child: "CB", SKU: "B"},
+		{Parent: "P", Child: "CC", SKU: "C"},
+		{SKU: "LONE", Sessions: 5, UnitsOrdered: 2, OrdProdSales: 40},
+	})
+	if _, ok := reports.AMZViews["B"]; ok {
+		t.Fatal("AMZViews has B, want rows with no sessions left out")
+	}
+
+	rl, err := loadRules(strings.NewReader(`{"Topseller": 3, "Fees": {"FBAClass": {"default": 3}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := (&engine{opts: publishRequest{ParentRollup: true}}).withRules(rl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svd := svDatas{}
+	for _, sku := range reports.skus() {
+		svd[sku] = svData{Cost: 5, AvailableQt: 100}
+	}
+	out, err := e.withSvData(svd).run(reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// P sold 3 over A, B and C; shares are units ordered plus one over
+	// P's 2 units plus 3 children.
+	tests := []struct {
+		sku   string
+		qt    int
+		share float64
+	}{
+		{"A", 2, 3.0 / 5},
+		{"B", 1, 1.0 / 5},
+		{"C", 1, 1.0 / 5},
+	}
+	for _, tt := range tests {
+		got, ok := out.CAData[tt.sku]
+		if !ok {
+			t.Errorf("%s not suggested, rejected %+v", tt.sku, out.Rejected[tt.sku])
+			continue
+		}
+		if got.QtySold != tt.qt || got.Parent == nil || got.Parent.QtySold != 3 || got.Parent.Share != tt.share {
+			t.Errorf("%s QtySold %d, Parent %+v; want %d of 3 at share %v", tt.sku, got.QtySold, got.Parent, tt.qt, tt.share)
+		}
+	}
+	if r := out.Rejected["LONE"]; r.Reason != rejBelowTopseller {
+		t.Errorf("LONE rejection = %+v, want %s on its own units", r, rejBelowTopseller)
+	}
+
+	// without rollup A and B are each under Topseller.
+	e, err = (&engine{}).withRules(rl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = e.withSvData(svd).run(reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sku := range []string{"A", "B"} {
+		if r := out.Rejected[sku]; r.Reason != rejBelowTopseller {
+			t.Errorf("no rollup: %s rejection = %+v, want %s", sku, r, rejBelowTopseller)
+		}
+	}
+}
@@ -0,0 +1,463 @@
+package stock
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Defaults used when rules.json leaves a field out.
+const (
+	defTopseller       = 1
+	defProfit          = 0.0
+	defFeePercentage   = 0.15
+	defDaysCoverd      = 30
+	defSalesMultiplier = 1.0
+	defQtMode          = qtCoverage
+	defLeadTimeDays    = 0
+	defServiceLevel    = 0.95
+	defForecast        = forecastFlat
+)
+
+// rulesProblem is one thing wrong with a rules file.
+type rulesProblem struct {
+	Path    string
+	Problem string
+}
+
+// rulesErrors lists every problem found in a rules file.
+type rulesErrors []rulesProblem
+
+func (re rulesErrors) Error() string {
+	msgs := make([]string, len(re))
+	for i, p := range re {
+		msgs[i] = p.Path + ": " + p.Problem
+	}
+	return "rules: " + strings.Join(msgs, "; ")
+}
+
+func (re *rulesErrors) add(path, problem string) {
+	*re = append(*re, rulesProblem{path, problem})
+}
+
+// rulesJSON is rules.json as written, so missing fields can be told
+// apart from zero values.
+type rulesJSON struct {
+	Topseller *int
+	Profit    *float64
+	Fees      *struct {
+		FeePercentage *float64
+		FBAClass      map[string]float64
+		Table         *string
+	}
+	DaysCoverd      *int
+	SalesMultiplier *float64
+	QtMode          *string
+	NetFBA          *bool
+	LeadTimeDays    *int
+	ServiceLevel    *float64
+	Forecast        *string
+	Seasonality     *seasonRules
+	Pricing         *pricingRules
+	Capacity        *capacityRules
+	Classes         map[string]ruleOverride
+	Brands          map[string]ruleOverride
+	BrandClasses    map[string]ruleOverride
+	Inbound         *inboundRules
+}
+
+// ruleOverride replaces the global rules for a brand, a classification
+// or a "brand/classification" pair. Only the fields set are replaced.
+type ruleOverride struct {
+	Topseller       *int     `json:",omitempty"`
+	Profit          *float64 `json:",omitempty"`
+	DaysCoverd      *int     `json:",omitempty"`
+	SalesMultiplier *float64 `json:",omitempty"`
+	LeadTimeDays    *int     `json:",omitempty"`
+	ServiceLevel    *float64 `json:",omitempty"`
+}
+
+// skuRule is the rules for one SKU after overrides. Override names the
+// override blocks used, most specific last.
+type skuRule struct {
+	Topseller       int
+	Profit          float64
+	DaysCoverd      int
+	SalesMultiplier float64
+	QtMode          string
+	NetFBA          bool
+	LeadTimeDays    int
+	ServiceLevel    float64
+	Forecast        string
+	Override        string
+}
+
+// overrideFields are the keys allowed in an override block.
+var overrideFields = []string{"Topseller", "Profit", "DaysCoverd", "SalesMultiplier", "LeadTimeDays", "ServiceLevel"}
+
+// loadRules reads a rules file, fills in the defaults above and range
+// checks it. Every problem is returned together as rulesErrors.
+func loadRules(r io.Reader) (*rulesFile, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	probs := rulesErrors{}
+
+	keys := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &keys); err != nil {
+		probs.add("$", err.Error())
+		return nil, probs
+	}
+	unknownKeys(keys, &probs, "", "Topseller", "Profit", "Fees", "DaysCoverd", "SalesMultiplier", "QtMode", "NetFBA", "LeadTimeDays", "ServiceLevel", "Forecast", "Seasonality", "Pricing", "Capacity", "Classes", "Brands", "BrandClasses", "Inbound")
+	for _, k := range sortedKeys(keys) {
+		v := keys[k]
+		switch {
+		case strings.EqualFold(k, "Fees"):
+			feeKeys := map[string]json.RawMessage{}
+			if json.Unmarshal(v, &feeKeys) == nil {
+				unknownKeys(feeKeys, &probs, "Fees.", "FeePercentage", "FBAClass", "Table")
+			}
+		case strings.EqualFold(k, "Seasonality"):
+			seasonKeys := map[string]json.RawMessage{}
+			if json.Unmarshal(v, &seasonKeys) == nil {
+				unknownKeys(seasonKeys, &probs, "Seasonality.", "GroupBy", "Snapshots")
+			}
+		case strings.EqualFold(k, "Pricing"):
+			priceKeys := map[string]json.RawMessage{}
+			if json.Unmarshal(v, &priceKeys) != nil {
+				continue
+			}
+			unknownKeys(priceKeys, &probs, "Pricing.", "Strategy", "Margin", "MAP", "Band")
+			for _, pk := range sortedKeys(priceKeys) {
+				if !strings.EqualFold(pk, "Band") {
+					continue
+				}
+				bandKeys := map[string]json.RawMessage{}
+				if json.Unmarshal(priceKeys[pk], &bandKeys) == nil {
+					unknownKeys(bandKeys, &probs, "Pricing.Band.", "Min", "Max")
+				}
+			}
+		case strings.EqualFold(k, "Capacity"):
+			capKeys := map[string]json.RawMessage{}
+			if json.Unmarshal(v, &capKeys) == nil {
+				unknownKeys(capKeys, &probs, "Capacity.", "Standard", "Oversize", "SKUs")
+			}
+		case strings.EqualFold(k, "Inbound"):
+			inKeys := map[string]json.RawMessage{}
+			if json.Unmarshal(v, &inKeys) != nil {
+				continue
+			}
+			unknownKeys(inKeys, &probs, "Inbound.", "PlanName", "ShipToCountry", "ShipFrom", "PrepOwner", "LabelingOwner", "CasePack", "SKUs")
+			for _, ik := range sortedKeys(inKeys) {
+				switch {
+				case strings.EqualFold(ik, "ShipFrom"):
+					fromKeys := map[string]json.RawMessage{}
+					if json.Unmarshal(inKeys[ik], &fromKeys) == nil {
+						unknownKeys(fromKeys, &probs, "Inbound.ShipFrom.", "Name", "AddressLine1", "AddressLine2", "City", "StateOrRegion", "PostalCode", "CountryCode", "District")
+					}
+				case strings.EqualFold(ik, "SKUs"):
+					blocks := map[string]map[string]json.RawMessage{}
+					if json.Unmarshal(inKeys[ik], &blocks) == nil {
+						for _, sku := range sortedKeys(blocks) {
+							unknownKeys(blocks[sku], &probs, `Inbound.SKUs["`+sku+`"].`, "PrepOwner", "LabelingOwner", "CasePack")
+						}
+					}
+				}
+			}
+		case strings.EqualFold(k, "Classes"), strings.EqualFold(k, "Brands"), strings.EqualFold(k, "BrandClasses"):
+			blocks := map[string]map[string]json.RawMessage{}
+			if json.Unmarshal(v, &blocks) != nil {
+				continue
+			}
+			for _, name := range sortedKeys(blocks) {
+				unknownKeys(blocks[name], &probs, k+`["`+name+`"].`, overrideFields...)
+			}
+		}
+	}
+
+	raw := rulesJSON{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		probs.add("$", err.Error())
+		return nil, probs
+	}
+
+	rl := &rulesFile{
+		Topseller:       defTopseller,
+		Profit:          defProfit,
+		DaysCoverd:      defDaysCoverd,
+		SalesMultiplier: defSalesMultiplier,
+		QtMode:          defQtMode,
+		LeadTimeDays:    defLeadTimeDays,
+		ServiceLevel:    defServiceLevel,
+		Forecast:        defForecast,
+	}
+	rl.Fees.FeePercentage = defFeePercentage
+	rl.Fees.Table = defFeeTable
+
+	if raw.Topseller != nil {
+		rl.Topseller = *raw.Topseller
+	}
+	if raw.Profit != nil {
+		rl.Profit = *raw.Profit
+	}
+	if raw.DaysCoverd != nil {
+		rl.DaysCoverd = *raw.DaysCoverd
+	}
+	if raw.SalesMultiplier != nil {
+		rl.SalesMultiplier = *raw.SalesMultiplier
+	}
+	if raw.QtMode != nil {
+		rl.QtMode = *raw.QtMode
+	}
+	if raw.NetFBA != nil {
+		rl.NetFBA = *raw.NetFBA
+	}
+	if raw.LeadTimeDays != nil {
+		rl.LeadTimeDays = *raw.LeadTimeDays
+	}
+	if raw.ServiceLevel != nil {
+		rl.ServiceLevel = *raw.ServiceLevel
+	}
+	if raw.Forecast != nil {
+		rl.Forecast = *raw.Forecast
+	}
+	if raw.Seasonality != nil {
+		rl.Seasonality = *raw.Seasonality
+	}
+	rl.Seasonality.defaults()
+	if raw.Pricing != nil {
+		rl.Pricing = *raw.Pricing
+	}
+	rl.Pricing.defaults()
+	if raw.Capacity != nil {
+		rl.Capacity = *raw.Capacity
+	}
+	if raw.Fees != nil {
+		if raw.Fees.FeePercentage != nil {
+			rl.Fees.FeePercentage = *raw.Fees.FeePercentage
+		}
+		rl.Fees.FBAClass = raw.Fees.FBAClass
+		if raw.Fees.Table != nil {
+			rl.Fees.Table = *raw.Fees.Table
+		}
+	}
+	rl.Classes = raw.Classes
+	rl.Brands = raw.Brands
+	rl.BrandClasses = raw.BrandClasses
+	if raw.Inbound != nil {
+		rl.Inbound = *raw.Inbound
+	}
+	rl.Inbound.defaults()
+
+	checkRule(&probs, "", ruleOverride{&rl.Topseller, &rl.Profit, &rl.DaysCoverd, &rl.SalesMultiplier, &rl.LeadTimeDays, &rl.ServiceLevel})
+	if rl.QtMode != qtCoverage && rl.QtMode != qtSafety {
+		probs.add("QtMode", `must be "`+qtCoverage+`" or "`+qtSafety+`", got "`+rl.QtMode+`"`)
+	}
+	if rl.Forecast != forecastFlat && rl.Forecast != forecastSeasonal {
+		probs.add("Forecast", `must be "`+forecastFlat+`" or "`+forecastSeasonal+`", got "`+rl.Forecast+`"`)
+	}
+	rl.Seasonality.check(&probs)
+	rl.Pricing.check(&probs)
+	rl.Capacity.check(&probs)
+	for _, o := range []struct {
+		name   string
+		blocks map[string]ruleOverride
+	}{
+		{"Classes", rl.Classes},
+		{"Brands", rl.Brands},
+		{"BrandClasses", rl.BrandClasses},
+	} {
+		for _, key := range sortedKeys(o.blocks) {
+			checkRule(&probs, o.name+`["`+key+`"].`, o.blocks[key])
+		}
+	}
+	for _, key := range sortedKeys(rl.BrandClasses) {
+		if !strings.Contains(key, "/") {
+			probs.add(`BrandClasses["`+key+`"]`, `key must be "brand/classification"`)
+		}
+	}
+	if _, err := fs.Stat(feeTables, rl.Fees.Table); err != nil || strings.ContainsRune(rl.Fees.Table, '/') {
+		probs.add("Fees.Table", `must be one of `+strings.Join(feeTableNames(), ", ")+`, got "`+rl.Fees.Table+`"`)
+	}
+	if rl.Fees.FeePercentage < 0 || rl.Fees.FeePercentage >= 1 {
+		probs.add("Fees.FeePercentage", "must be from 0 up to 1, got "+ftoa(rl.Fees.FeePercentage))
+	}
+	if _, ok := rl.Fees.FBAClass["default"]; !ok {
+		probs.add(`Fees.FBAClass["default"]`, "is required")
+	}
+	rl.Inbound.check(&probs)
+	for _, class := range sortedKeys(rl.Fees.FBAClass) {
+		if fee := rl.Fees.FBAClass[class]; fee < 0 {
+			probs.add(`Fees.FBAClass["`+class+`"]`, "must be 0 or more, got "+ftoa(fee))
+		}
+	}
+
+	if len(probs) > 0 {
+		return rl, probs
+	}
+	return rl, nil
+}
+
+// checkRule range checks the fields set in o.
+func checkRule(probs *rulesErrors, path string, o ruleOverride) {
+	if o.Topseller != nil && *o.Topseller < 0 {
+		probs.add(path+"Topseller", "must be 0 or more, got "+strconv.Itoa(*o.Topseller))
+	}
+	if o.Profit != nil && *o.Profit < 0 {
+		probs.add(path+"Profit", "must be 0 or more, got "+ftoa(*o.Profit))
+	}
+	if o.DaysCoverd != nil && *o.DaysCoverd <= 0 {
+		probs.add(path+"DaysCoverd", "must be more than 0, got "+strconv.Itoa(*o.DaysCoverd))
+	}
+	if o.SalesMultiplier != nil && *o.SalesMultiplier <= 0 {
+		probs.add(path+"SalesMultiplier", "must be more than 0, got "+ftoa(*o.SalesMultiplier))
+	}
+	if o.LeadTimeDays != nil && *o.LeadTimeDays < 0 {
+		probs.add(path+"LeadTimeDays", "must be 0 or more, got "+strconv.Itoa(*o.LeadTimeDays))
+	}
+	if o.ServiceLevel != nil && (*o.ServiceLevel < 0.5 || *o.ServiceLevel >= 1) {
+		probs.add(path+"ServiceLevel", "must be from 0.5 up to 1, got "+ftoa(*o.ServiceLevel))
+	}
+}
+
+// resolve works out the rules for a SKU of brand and class.
+// Precedence, lowest to highest: global rules, Classes[class],
+// Brands[brand], BrandClasses["brand/class"].
+func (rl *rulesFile) resolve(brand, class string) skuRule {
+	sr := skuRule{
+		Topseller:       rl.Topseller,
+		Profit:          rl.Profit,
+		DaysCoverd:      rl.DaysCoverd,
+		SalesMultiplier: rl.SalesMultiplier,
+		QtMode:          rl.QtMode,
+		NetFBA:          rl.NetFBA,
+		LeadTimeDays:    rl.LeadTimeDays,
+		ServiceLevel:    rl.ServiceLevel,
+		Forecast:        rl.Forecast,
+	}
+
+	used := []string{}
+	for _, o := range []struct {
+		name   string
+		blocks map[string]ruleOverride
+		key    string
+	}{
+		{"Classes", rl.Classes, class},
+		{"Brands", rl.Brands, brand},
+		{"BrandClasses", rl.BrandClasses, brand + "/" + class},
+	} {
+		ov, ok := o.blocks[o.key]
+		if !ok {
+			continue
+		}
+		sr.apply(ov)
+		used = append(used, o.name+`["`+o.key+`"]`)
+	}
+	sr.Override = strings.Join(used, ", ")
+	return sr
+}
+
+func (sr *skuRule) apply(o ruleOverride) {
+	if o.Topseller != nil {
+		sr.Topseller = *o.Topseller
+	}
+	if o.Profit != nil {
+		sr.Profit = *o.Profit
+	}
+	if o.DaysCoverd != nil {
+		sr.DaysCoverd = *o.DaysCoverd
+	}
+	if o.SalesMultiplier != nil {
+		sr.SalesMultiplier = *o.SalesMultiplier
+	}
+	if o.LeadTimeDays != nil {
+		sr.LeadTimeDays = *o.LeadTimeDays
+	}
+	if o.ServiceLevel != nil {
+		sr.ServiceLevel = *o.ServiceLevel
+	}
+}
+
+// minTopseller is the lowest Topseller of the global rules and every
+// override, used to pre-filter CA data before brands are known.
+func (rl *rulesFile) minTopseller() int {
+	min := rl.Topseller
+	for _, blocks := range []map[string]ruleOverride{rl.Classes, rl.Brands, rl.BrandClasses} {
+		for _, o := range blocks {
+			if o.Topseller != nil && *o.Topseller < min {
+				min = *o.Topseller
+			}
+		}
+	}
+	return min
+}
+
+// unknownKeys flags keys that are not known rules fields, which are
+// usually typos.
+func unknownKeys(keys map[string]json.RawMessage, probs *rulesErrors, prefix string, known ...string) {
+	names := []string{}
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		found := false
+		for _, kn := range known {
+			if strings.EqualFold(k, kn) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			probs.add(prefix+k, "unknown field")
+		}
+	}
+}
+
+// sortedKeys returns the keys of a string keyed map in order, so
+// problems are reported the same way every time.
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+type rulesCheck struct {
+	Valid    bool
+	Problems rulesErrors
+	Rules    *rulesFile
+}
+
+// validateRules checks the rules file in the request body and sends back
+// every problem found along with the rules after defaults.
+func validateRules(w http.ResponseWriter, r *http.Request) {
+	rl, err := loadRules(r.Body)
+	chk := rulesCheck{Valid: err == nil, Rules: rl}
+	if err != nil {
+		probs, ok := err.(rulesErrors)
+		if !ok {
+			errLog.Println("loadRules:", err)
+			http.Error(w, "Error reading rules", http.StatusBadRequest)
+			return
+		}
+		chk.Problems = probs
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+
+	json.NewEncoder(w).Encode(&chk)
+}
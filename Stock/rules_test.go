@@ -0,0 +1,186 @@
+package stock
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// minRules is the least a rules file must have.
+const minRules = `{"Fees": {"FBAClass": {"default": 3}}}`
+
+// problemPaths lists the paths of err's problems.
+func problemPaths(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	probs, ok := err.(rulesErrors)
+	if !ok {
+		t.Fatalf("err = %T %v, want rulesErrors", err, err)
+	}
+	paths := []string{}
+	for _, p := range probs {
+		paths = append(paths, p.Path)
+	}
+	return paths
+}
+
+func TestLoadRulesDefaults(t *testing.T) {
+	rl, err := loadRules(strings.NewReader(minRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := rl.resolve("", "")
+	want := skuRule{
+		Topseller:       defTopseller,
+		Profit:          defProfit,
+		DaysCoverd:      defDaysCoverd,
+		SalesMultiplier: defSalesMultiplier,
+		QtMode:          defQtMode,
+		LeadTimeDays:    defLeadTimeDays,
+		ServiceLevel:    defServiceLevel,
+		Forecast:        defForecast,
+	}
+	if got != want {
+		t.Errorf("defaults = %+v, want %+v", got, want)
+	}
+	if rl.Fees.FeePercentage != defFeePercentage || rl.Fees.Table != defFeeTable {
+		t.Errorf("Fees = %+v, want FeePercentage %v and Table %s", rl.Fees, defFeePercentage, defFeeTable)
+	}
+	if rl.Seasonality.GroupBy != groupBrand {
+		t.Errorf("Seasonality.GroupBy = %q, want %q", rl.Seasonality.GroupBy, groupBrand)
+	}
+
+	// zero values that are set are kept, not defaulted.
+	rl, err = loadRules(strings.NewReader(`{"Topseller": 0, "Fees": {"FeePercentage": 0, "FBAClass": {"default": 3}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rl.Topseller != 0 || rl.Fees.FeePercentage != 0 {
+		t.Errorf("Topseller %d, FeePercentage %v; want both 0", rl.Topseller, rl.Fees.FeePercentage)
+	}
+}
+
+func TestLoadRulesProblems(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		want  []string
+	}{
+		{"minimal", minRules, nil},
+		{"not json", `{"Topseller": }`, []string{"$"}},
+		{"wrong type", `{"Topseller": "one", "Fees": {"FBAClass": {"default": 3}}}`, []string{"$"}},
+		{"no default class fee", `{}`, []string{`Fees.FBAClass["default"]`}},
+		{
+			name:  "ranges",
+			rules: `{"Topseller": -1, "Profit": -2, "DaysCoverd": 0, "SalesMultiplier": 0, "LeadTimeDays": -1, "ServiceLevel": 1, "Fees": {"FeePercentage": 1, "FBAClass": {"default": 3, "big": -1}}}`,
+			want:  []string{"Topseller", "Profit", "DaysCoverd", "SalesMultiplier", "LeadTimeDays", "ServiceLevel", "Fees.FeePercentage", `Fees.FBAClass["big"]`},
+		},
+		{
+			name:  "modes",
+			rules: `{"QtMode": "fast", "Forecast": "guess", "Fees": {"FBAClass": {"default": 3}}}`,
+			want:  []string{"QtMode", "Forecast"},
+		},
+		{
+			name:  "fee table",
+			rules: `{"Fees": {"Table": "../rules.json", "FBAClass": {"default": 3}}}`,
+			want:  []string{"Fees.Table"},
+		},
+		{
+			name:  "override ranges",
+			rules: `{"Brands": {"Acme": {"DaysCoverd": -5}}, "BrandClasses": {"Acme": {"Profit": 1}}, "Fees": {"FBAClass": {"default": 3}}}`,
+			want:  []string{`Brands["Acme"].DaysCoverd`, `BrandClasses["Acme"]`},
+		},
+		{
+			name:  "unknown keys",
+			rules: `{"TopSeler": 2, "Fees": {"FBAclass": {"default": 3}, "Fee": 1}, "Classes": {"toys": {"QtMode": "safety"}}}`,
+			want:  []string{"TopSeler", `Classes["toys"].QtMode`, "Fees.Fee"},
+		},
+		{
+			name:  "unknown Inbound keys",
+			rules: `{"Inbound": {"CasePak": 12, "ShipFrom": {"Zip": "10001", "City": "NY"}, "SKUs": {"RED-1": {"CasePak": 6, "PrepOwner": "AMAZON"}}}, "Fees": {"FBAClass": {"default": 3}}}`,
+			want:  []string{"Inbound.CasePak", `Inbound.SKUs["RED-1"].CasePak`, "Inbound.ShipFrom.Zip"},
+		},
+		{
+			name:  "unknown Pricing keys",
+			rules: `{"Pricing": {"Stratgy": "margin", "Band": {"Min": 0.9, "Top": 1.1}}, "Fees": {"FBAClass": {"default": 3}}}`,
+			want:  []string{"Pricing.Stratgy", "Pricing.Band.Top"},
+		},
+		{
+			name:  "Inbound ranges",
+			rules: `{"Inbound": {"CasePack": -1, "PrepOwner": "ME", "SKUs": {"RED-1": {"LabelingOwner": "YOU"}}}, "Fees": {"FBAClass": {"default": 3}}}`,
+			want:  []string{"Inbound.PrepOwner", "Inbound.CasePack", `Inbound.SKUs["RED-1"].LabelingOwner`},
+		},
+		{
+			name:  "keys ignore case",
+			rules: `{"topseller": 2, "fees": {"fbaclass": {"default": 3}}}`,
+		},
+	}
+	for _, tt := range tests {
+		_, err := loadRules(strings.NewReader(tt.rules))
+		got := problemPaths(t, err)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: problems %v (%v), want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	tests := []struct {
+		rules string
+		code  int
+		body  string
+	}{
+		{minRules, http.StatusOK, `"Valid":true`},
+		{`{"Topseller": -1}`, http.StatusUnprocessableEntity, `"Path":"Topseller"`},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		validateRules(w, httptest.NewRequest("POST", "/rules/validate", strings.NewReader(tt.rules)))
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("validateRules %s = %d %s, want %d with %s", tt.rules, w.Code, w.Body, tt.code, tt.body)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	rl, err := loadRules(strings.NewReader(`{
+		"Topseller": 5, "DaysCoverd": 30, "Profit": 1,
+		"Fees": {"FBAClass": {"default": 3}},
+		"Classes": {"toys": {"DaysCoverd": 40, "Topseller": 4, "Profit": 2}},
+		"Brands": {"Acme": {"DaysCoverd": 50, "Topseller": 3}},
+		"BrandClasses": {"Acme/toys": {"DaysCoverd": 60}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		brand, class string
+		days, top    int
+		profit       float64
+		override     string
+	}{
+		{"Other", "games", 30, 5, 1, ""},
+		{"Other", "toys", 40, 4, 2, `Classes["toys"]`},
+		{"Acme", "games", 50, 3, 1, `Brands["Acme"]`},
+		{"Acme", "toys", 60, 3, 2, `Classes["toys"], Brands["Acme"], BrandClasses["Acme/toys"]`},
+		// keys are matched exactly.
+		{"acme", "Toys", 30, 5, 1, ""},
+	}
+	for _, tt := range tests {
+		sr := rl.resolve(tt.brand, tt.class)
+		if sr.DaysCoverd != tt.days || sr.Topseller != tt.top || sr.Profit != tt.profit || sr.Override != tt.override {
+			t.Errorf("resolve(%s, %s) = DaysCoverd %d, Topseller %d, Profit %v, Override %q; want %d, %d, %v, %q",
+				tt.brand, tt.class, sr.DaysCoverd, sr.Topseller, sr.Profit, sr.Override, tt.days, tt.top, tt.profit, tt.override)
+		}
+		if sr.SalesMultiplier != defSalesMultiplier || sr.QtMode != defQtMode {
+			t.Errorf("resolve(%s, %s) changed fields no override sets: %+v", tt.brand, tt.class, sr)
+		}
+	}
+	if got := rl.minTopseller(); got != 3 {
+		t.Errorf("minTopseller = %d, want 3", got)
+	}
+}
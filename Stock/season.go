@@ -0,0 +1,203 @@
+package stock
+
+import (
+	"strconv"
+	"time"
+)
+
+// Demand forecasts set by rules Forecast.
+const (
+	// forecastFlat is one average daily rate over the CA date range.
+	forecastFlat = "flat"
+	// forecastSeasonal projects demand with weekly seasonal indices.
+	forecastSeasonal = "seasonal"
+
+	groupBrand = "brand"
+	groupClass = "class"
+
+	day = 24 * time.Hour
+)
+
+// seasonRules sets up the seasonal forecast, from rules.json Seasonality.
+type seasonRules struct {
+	// GroupBy fits indices per "brand" or per "class".
+	GroupBy string
+	// Snapshots is how many stored runs to add CA history from.
+	Snapshots int
+}
+
+func (sr *seasonRules) defaults() {
+	if sr.GroupBy == "" {
+		sr.GroupBy = groupBrand
+	}
+}
+
+func (sr *seasonRules) check(probs *rulesErrors) {
+	if sr.GroupBy != groupBrand && sr.GroupBy != groupClass {
+		probs.add("Seasonality.GroupBy", `must be "`+groupBrand+`" or "`+groupClass+`", got "`+sr.GroupBy+`"`)
+	}
+	if sr.Snapshots < 0 {
+		probs.add("Seasonality.Snapshots", "must be 0 or more, got "+strconv.Itoa(sr.Snapshots))
+	}
+}
+
+// seasonIndex is demand by ISO week against the group's average;
+// 1 is an average week.
+type seasonIndex [53]float64
+
+// at is the index for the week t falls in.
+func (si *seasonIndex) at(t time.Time) float64 {
+	_, w := t.ISOWeek()
+	return si[w-1]
+}
+
+// sum adds up the index of every day from start for days days.
+func (si *seasonIndex) sum(start time.Time, days float64) float64 {
+	var s float64
+	for d := 0; float64(d) < days; d++ {
+		s += si.at(start.Add(time.Duration(d) * day))
+	}
+	return s
+}
+
+// fitSeasons works out weekly indices for every brand or class from the
+// CA sales periods of its SKUs.
+//
+// Each period's units are spread evenly over its days and summed by
+// ISO week. A week's index is its daily rate over the group's, shrunk
+// toward 1 by one week of average sales so weeks seen in few reports
+// don't swing the forecast. Weeks with no data stay at 1.
+func fitSeasons(periods map[string][]salesPeriod, svd svDatas, groupBy string) map[string]*seasonIndex {
+	type weeks struct {
+		units, days     [53]float64
+		allUnits, total float64
+	}
+	groups := map[string]*weeks{}
+	for sku, ps := range periods {
+		sv, ok := svd[sku]
+		if !ok {
+			continue
+		}
+		g := seasonGroup(sv, groupBy)
+		wk, ok := groups[g]
+		if !ok {
+			wk = &weeks{}
+			groups[g] = wk
+		}
+		for _, p := range ps {
+			if p.days <= 0 || p.start.IsZero() {
+				continue
+			}
+			rate := p.daily()
+			for d := 0; float64(d) < p.days; d++ {
+				_, w := p.start.Add(time.Duration(d) * day).ISOWeek()
+				wk.units[w-1] += rate
+				wk.days[w-1]++
+			}
+			wk.allUnits += float64(p.qty)
+			wk.total += p.days
+		}
+	}
+
+	idx := map[string]*seasonIndex{}
+	for g, wk := range groups {
+		si := &seasonIndex{}
+		mean := 0.0
+		if wk.total > 0 {
+			mean = wk.allUnits / wk.total
+		}
+		for w := range si {
+			si[w] = 1
+			if mean <= 0 || wk.days[w] == 0 {
+				continue
+			}
+			prior := 7 * mean
+			si[w] = (wk.units[w] + prior) / (mean*wk.days[w] + prior)
+		}
+		idx[g] = si
+	}
+	return idx
+}
+
+func seasonGroup(sv svData, groupBy string) string {
+	if groupBy == groupClass {
+		return sv.Class
+	}
+	return sv.Brand
+}
+
+// seasonFactor is how much busier the days ahead are than the days
+// sku's sales were measured over: the average index from start for days
+// over the average index of its CA periods. The flat daily rate times
+// this is the SKU's out of season rate put back into season.
+func (filz *fbaStockFiles) seasonFactor(sku string, si *seasonIndex, start time.Time, days float64) float64 {
+	var measured, weight float64
+	for _, p := range filz.periods[sku] {
+		if p.days <= 0 || p.start.IsZero() {
+			continue
+		}
+		measured += p.days
+		weight += si.sum(p.start, p.days)
+	}
+	if weight <= 0 || days <= 0 {
+		return 1
+	}
+	return (si.sum(start, days) / days) / (weight / measured)
+}
+
+// addHistory adds the CA data of the last n stored runs to the sales
+// periods, skipping date ranges the current reports already cover.
+func (filz *fbaStockFiles) addHistory(n int) error {
+	if n <= 0 {
+		return nil
+	}
+	store, err := openSnapshots()
+	if err != nil {
+		return err
+	}
+	defer store.close()
+
+	infos, err := store.list()
+	if err != nil {
+		return err
+	}
+	if len(infos) > n {
+		infos = infos[len(infos)-n:]
+	}
+
+	for _, info := range infos {
+		s, err := store.load(info.ID)
+		if err != nil {
+			return err
+		}
+		for _, name := range sortedKeys(s.Inputs) {
+			rows := s.Inputs[name]
+			kind, err := detectReport(name, rows)
+			if err != nil || kind != caReport {
+				continue
+			}
+			var t []top
+			if err := unmarshalReport(parsedReport{reportFile{Name: name}, kind, rows}, &t); err != nil {
+				return err
+			}
+			for _, row := range t {
+				filz.addPeriod(row)
+			}
+		}
+	}
+	return nil
+}
+
+// addPeriod adds row's date range to the SKU's sales periods unless it
+// overlaps one that is there.
+func (filz *fbaStockFiles) addPeriod(row top) {
+	span := dateSpan{row.ReportStartDate, row.ReportEndDate}
+	if overlapsPeriod(span, filz.periods[row.SKU]) {
+		return
+	}
+	sp := salesPeriod{row.ReportStartDate, span.days(), row.QtySold}
+	if filz.periods == nil {
+		filz.periods = make(map[string][]salesPeriod)
+	}
+	filz.periods[row.SKU] = append(filz.periods[row.SKU], sp)
+}
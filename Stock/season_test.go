@@ -0,0 +1,100 @@
+package stock
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFitSeasons(t *testing.T) {
+	// 2018-01-01 is a Monday, the start of ISO week 1.
+	periods := map[string][]salesPeriod{
+		"A":    {{date(1, 1), 7, 21}},
+		"B":    {{date(1, 8), 7, 7}},
+		"NONE": {{date(1, 1), 0, 5}},
+		"NOSV": {{date(1, 1), 7, 70}},
+	}
+	svd := svDatas{
+		"A":    {Brand: "Acme", Class: "shirts"},
+		"B":    {Brand: "Acme", Class: "hats"},
+		"NONE": {Brand: "Empty", Class: "hats"},
+		"NOCA": {Brand: "Quiet", Class: "socks"},
+	}
+
+	// Acme sells 28 over 14 days, 2 a day; week 1 at 3 a day and week 2
+	// at 1, each shrunk toward 1 by a week of 2 a day.
+	idx := fitSeasons(periods, svd, groupBrand)
+	want := map[string]map[int]float64{
+		"Acme":  {1: 35.0 / 28, 2: 21.0 / 28},
+		"Empty": {},
+	}
+	if len(idx) != len(want) {
+		t.Fatalf("brands = %v, want Acme and Empty", sortedKeys(idx))
+	}
+	for g, weeks := range want {
+		si := idx[g]
+		if si == nil {
+			t.Fatalf("no index for %s", g)
+		}
+		for w := 1; w <= len(si); w++ {
+			exp, ok := weeks[w]
+			if !ok {
+				exp = 1
+			}
+			if si[w-1] != exp {
+				t.Errorf("%s week %d = %v, want %v", g, w, si[w-1], exp)
+			}
+		}
+	}
+	// a brand with no CA history has no index at all.
+	if _, ok := idx["Quiet"]; ok {
+		t.Error("Quiet has an index, want none")
+	}
+
+	// by class A and B are apart, so each is its own average.
+	idx = fitSeasons(periods, svd, groupClass)
+	for _, c := range []string{"shirts", "hats"} {
+		si := idx[c]
+		if si == nil {
+			t.Fatalf("no index for class %s", c)
+		}
+		for w := range si {
+			if si[w] != 1 {
+				t.Errorf("class %s week %d = %v, want 1", c, w+1, si[w])
+			}
+		}
+	}
+}
+
+func TestSeasonFactor(t *testing.T) {
+	si := &seasonIndex{}
+	for w := range si {
+		si[w] = 1
+	}
+	si[0], si[1] = 1.25, 0.75
+
+	filz := &fbaStockFiles{periods: map[string][]salesPeriod{
+		"A":    {{date(1, 1), 7, 21}},
+		"ZERO": {{date(1, 1), 0, 3}},
+	}}
+	tests := []struct {
+		sku   string
+		start int
+		days  float64
+		want  float64
+	}{
+		// sold in week 1, ahead is week 2.
+		{"A", 8, 7, 0.75 / 1.25},
+		{"A", 1, 7, 1},
+		// half week 2, half week 3.
+		{"A", 11, 8, (4*0.75 + 4) / 8 / 1.25},
+		{"A", 8, 0, 1},
+		{"ZERO", 8, 7, 1},
+		{"MISSING", 8, 7, 1},
+	}
+	for _, tt := range tests {
+		got := filz.seasonFactor(tt.sku, si, date(1, tt.start), tt.days)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("seasonFactor(%s, Jan %d, %v) = %v, want %v", tt.sku, tt.start, tt.days, got, tt.want)
+		}
+	}
+}
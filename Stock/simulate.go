@@ -0,0 +1,199 @@
+package stock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// simCurrent names the run with the rules file as it is.
+	simCurrent = "current"
+	// simNotInReports is the reason for a SKU a candidate never saw.
+	simNotInReports = "not_in_reports"
+)
+
+// simulation is what each candidate rule set would do to the same
+// reports, for what-if checks before rules.json is changed.
+type simulation struct {
+	Totals map[string]simTotals
+	// Problems are candidates that failed to load or run.
+	Problems map[string]string `json:",omitempty"`
+	// SKUs compares every SKU suggested by any candidate, by SKU.
+	SKUs []simSKU
+}
+
+// simTotals sums a candidate's Suggested output.
+type simTotals struct {
+	SKUs   int
+	Units  int
+	Cost   float64
+	Profit float64
+}
+
+type simSKU struct {
+	SKU     string
+	Results map[string]simResult
+}
+
+// simResult is one candidate's take on a SKU. Rejected is the reason
+// when the candidate dropped it.
+type simResult struct {
+	SugQt    int
+	Cost     float64
+	EstProf  float64
+	Rejected string `json:",omitempty"`
+}
+
+// simulate runs reports with the rules file and with each candidate
+// side by side. A candidate holds only the rules.json fields it
+// changes; the rest come from the rules file. SKU Vault is asked once
+// for every SKU in the reports, unless e already has its data, and the
+// data shared by all candidates.
+func (e *engine) simulate(reports *fbaStockFiles, candidates map[string]json.RawMessage) (simulation, error) {
+	sim := simulation{
+		Totals:   map[string]simTotals{},
+		Problems: map[string]string{},
+	}
+	if _, ok := candidates[simCurrent]; ok {
+		return sim, errors.New(`simulate: "` + simCurrent + `" is kept for the rules file`)
+	}
+
+	if e.svd == nil {
+		svd, err := e.fetchSvData(reports.skus())
+		if err != nil {
+			return sim, err
+		}
+		e = e.withSvData(svd)
+	}
+
+	engines := map[string]*engine{simCurrent: e}
+	for _, name := range sortedKeys(candidates) {
+		rl, err := patchRules(e.rules, candidates[name])
+		if err != nil {
+			sim.Problems[name] = err.Error()
+			continue
+		}
+		ce, err := e.withRules(rl)
+		if err != nil {
+			sim.Problems[name] = err.Error()
+			continue
+		}
+		engines[name] = ce
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	runs := map[string]*fbaStockFiles{}
+	for name, ce := range engines {
+		wg.Add(1)
+		go func(name string, ce *engine) {
+			defer wg.Done()
+			out, err := ce.run(reports)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				sim.Problems[name] = err.Error()
+				return
+			}
+			runs[name] = out
+		}(name, ce)
+	}
+	wg.Wait()
+
+	skus := map[string]map[string]simResult{}
+	for name, out := range runs {
+		tot := simTotals{}
+		for sku, t := range out.CAData {
+			res := simResult{
+				SugQt:   t.SugQt,
+				Cost:    t.Cost * float64(t.SugQt),
+				EstProf: t.EstProf * float64(t.SugQt),
+			}
+			tot.SKUs++
+			tot.Units += res.SugQt
+			tot.Cost += res.Cost
+			tot.Profit += res.EstProf
+			if skus[sku] == nil {
+				skus[sku] = map[string]simResult{}
+			}
+			skus[sku][name] = res
+		}
+		sim.Totals[name] = tot
+	}
+	for sku, results := range skus {
+		for name, out := range runs {
+			if _, ok := results[name]; ok {
+				continue
+			}
+			results[name] = simResult{Rejected: missingReason(out, sku)}
+		}
+		sim.SKUs = append(sim.SKUs, simSKU{sku, results})
+	}
+	sort.Slice(sim.SKUs, func(i, j int) bool { return sim.SKUs[i].SKU < sim.SKUs[j].SKU })
+
+	if _, ok := runs[simCurrent]; !ok {
+		return sim, errors.New("simulate: " + sim.Problems[simCurrent])
+	}
+	return sim, nil
+}
+
+// missingReason is why out has no suggestion for sku.
+func missingReason(out *fbaStockFiles, sku string) string {
+	if rej, ok := out.Rejected[sku]; ok {
+		return rej.Reason
+	}
+	return simNotInReports
+}
+
+// patchRules lays the fields of patch over base and loads the result as
+// a rules file, so candidates are checked like rules.json. Objects are
+// merged key by key, with keys matched regardless of case like
+// encoding/json; anything else in patch replaces base.
+func patchRules(base *rulesFile, patch json.RawMessage) (*rulesFile, error) {
+	b, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	over := map[string]interface{}{}
+	if err := json.Unmarshal(patch, &over); err != nil {
+		return nil, err
+	}
+	mergeJSON(doc, over)
+
+	b, err = json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return loadRules(bytes.NewReader(b))
+}
+
+func mergeJSON(dst, src map[string]interface{}) {
+	for k, v := range src {
+		for dk := range dst {
+			if dk != k && strings.EqualFold(dk, k) {
+				dst[k] = dst[dk]
+				delete(dst, dk)
+				break
+			}
+		}
+		sub, ok := v.(map[string]interface{})
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		cur, ok := dst[k].(map[string]interface{})
+		if !ok {
+			dst[k] = sub
+			continue
+		}
+		mergeJSON(cur, sub)
+	}
+}
@@ -0,0 +1,149 @@
+package stock
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		dst, src string
+		want     string
+	}{
+		{"new key", `{"A": 1}`, `{"B": 2}`, `{"A": 1, "B": 2}`},
+		{"replace value", `{"A": 1, "B": 2}`, `{"A": 3}`, `{"A": 3, "B": 2}`},
+		{"merge objects", `{"A": {"X": 1, "Y": 2}}`, `{"A": {"Y": 3, "Z": 4}}`, `{"A": {"X": 1, "Y": 3, "Z": 4}}`},
+		{"merge deep", `{"A": {"B": {"X": 1}}}`, `{"A": {"B": {"Y": 2}}}`, `{"A": {"B": {"X": 1, "Y": 2}}}`},
+		{"key case", `{"Topseller": 1, "Fees": {"FBAClass": {"default": 3}}}`, `{"topseller": 2, "fees": {"fbaclass": {"toys": 4}}}`,
+			`{"topseller": 2, "fees": {"fbaclass": {"default": 3, "toys": 4}}}`},
+		{"object over value", `{"A": 1}`, `{"A": {"X": 1}}`, `{"A": {"X": 1}}`},
+		{"value over object", `{"A": {"X": 1}}`, `{"A": 2}`, `{"A": 2}`},
+		{"array replaced", `{"A": [1, 2]}`, `{"A": [3]}`, `{"A": [3]}`},
+		{"null replaces", `{"A": {"X": 1}}`, `{"A": null}`, `{"A": null}`},
+	}
+	for _, tt := range tests {
+		var dst, src, want map[string]interface{}
+		for _, j := range []struct {
+			s string
+			v *map[string]interface{}
+		}{{tt.dst, &dst}, {tt.src, &src}, {tt.want, &want}} {
+			if err := json.Unmarshal([]byte(j.s), j.v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		mergeJSON(dst, src)
+		if !reflect.DeepEqual(dst, want) {
+			t.Errorf("%s: got %v, want %v", tt.name, dst, want)
+		}
+	}
+}
+
+func TestPatchRules(t *testing.T) {
+	base, err := loadRules(strings.NewReader(`{"Topseller": 4, "Profit": 2, "Fees": {"FeePercentage": 0.12, "FBAClass": {"default": 3}}, "Brands": {"Acme": {"Profit": 5}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rl, err := patchRules(base, json.RawMessage(`{"profit": 3, "Fees": {"FBAClass": {"toys": 4}}, "Brands": {"Acme": {"DaysCoverd": 60}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rl.Topseller != 4 || rl.Profit != 3 {
+		t.Errorf("Topseller %d Profit %v, want 4 and 3", rl.Topseller, rl.Profit)
+	}
+	if rl.Fees.FeePercentage != 0.12 || !reflect.DeepEqual(rl.Fees.FBAClass, map[string]float64{"default": 3, "toys": 4}) {
+		t.Errorf("Fees = %+v, want 0.12 and both classes", rl.Fees)
+	}
+	if acme := rl.Brands["Acme"]; acme.Profit == nil || *acme.Profit != 5 || acme.DaysCoverd == nil || *acme.DaysCoverd != 60 {
+		t.Errorf("Brands Acme = %+v, want Profit 5 and DaysCoverd 60", acme)
+	}
+	if base.Profit != 2 || len(base.Fees.FBAClass) != 1 {
+		t.Errorf("patchRules changed its base: %+v", base)
+	}
+
+	bad := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{"range", `{"Topseller": -1}`, "Topseller"},
+		{"unknown key", `{"Topselr": 5}`, "Topselr"},
+		{"not an object", `[1]`, "cannot unmarshal"},
+	}
+	for _, tt := range bad {
+		if _, err := patchRules(base, json.RawMessage(tt.patch)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	reports := &fbaStockFiles{}
+	reports.mapDataCA([]top{
+		{SKU: "A", QtySold: 10, ReportStartDate: date(1, 1), ReportEndDate: date(1, 31)},
+		{SKU: "B", QtySold: 3, ReportStartDate: date(1, 1), ReportEndDate: date(1, 31)},
+	})
+	reports.mapDataView([]amzViewsH{
+		{SKU: "A", Sessions: 10, UnitsOrdered: 10, OrdProdSales: 200},
+		{SKU: "B", Sessions: 10, UnitsOrdered: 3, OrdProdSales: 60},
+	})
+	rl, err := loadRules(strings.NewReader(`{"Topseller": 1, "Profit": 0, "Fees": {"FBAClass": {"default": 3}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := (&engine{}).withRules(rl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = e.withSvData(svDatas{
+		"A": {Cost: 5, AvailableQt: 100},
+		"B": {Cost: 5, AvailableQt: 100},
+	})
+
+	if _, err := e.simulate(reports, map[string]json.RawMessage{simCurrent: json.RawMessage(`{}`)}); err == nil {
+		t.Error(`simulate with a "current" candidate: want error`)
+	}
+
+	sim, err := e.simulate(reports, map[string]json.RawMessage{
+		"strict": json.RawMessage(`{"Topseller": 5}`),
+		"typo":   json.RawMessage(`{"Topselr": 5}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sim.Problems["typo"]; !ok || len(sim.Problems) != 1 {
+		t.Errorf("Problems = %v, want typo only", sim.Problems)
+	}
+	if len(sim.Totals) != 2 || sim.Totals[simCurrent].SKUs != 2 || sim.Totals["strict"].SKUs != 1 {
+		t.Errorf("Totals = %+v, want 2 SKUs for current and 1 for strict", sim.Totals)
+	}
+	if len(sim.SKUs) != 2 || sim.SKUs[0].SKU != "A" || sim.SKUs[1].SKU != "B" {
+		t.Fatalf("SKUs = %+v, want A and B", sim.SKUs)
+	}
+	for _, s := range sim.SKUs {
+		cur, strict := s.Results[simCurrent], s.Results["strict"]
+		if cur.Rejected != "" || cur.SugQt == 0 || cur.Cost != 5*float64(cur.SugQt) {
+			t.Errorf("%s current = %+v, want suggested at cost 5", s.SKU, cur)
+		}
+		switch s.SKU {
+		case "A":
+			if strict != cur {
+				t.Errorf("A strict = %+v, want as current %+v", strict, cur)
+			}
+		case "B":
+			if strict.Rejected != rejBelowTopseller || strict.SugQt != 0 {
+				t.Errorf("B strict = %+v, want %s", strict, rejBelowTopseller)
+			}
+		}
+	}
+	var units int
+	for _, s := range sim.SKUs {
+		units += s.Results[simCurrent].SugQt
+	}
+	if sim.Totals[simCurrent].Units != units {
+		t.Errorf("current Units = %d, want the %d suggested", sim.Totals[simCurrent].Units, units)
+	}
+}
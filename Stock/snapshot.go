@@ -0,0 +1,379 @@
+package stock
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	runsBucket = []byte("runs")
+	// indexBucket holds the snapshotInfo of each run, so runs can be
+	// listed without reading their data.
+	indexBucket = []byte("index")
+)
+
+// snapshot is one Stock run: what went in and what came out.
+type snapshot struct {
+	ID      string
+	Time    time.Time
+	Request publishRequest
+	Rules   *rulesFile
+	// Inputs are the rows of each report file used, by file name.
+	Inputs map[string][][]string
+	Output apiRespond
+}
+
+// snapshotInfo lists a run without its data.
+type snapshotInfo struct {
+	ID        string
+	Time      time.Time
+	Suggested int
+	Rejected  int
+}
+
+// snapshotStore keeps Stock runs.
+type snapshotStore interface {
+	save(s *snapshot) error
+	load(id string) (*snapshot, error)
+	list() ([]snapshotInfo, error)
+	close() error
+}
+
+// storeMu lets one store be open at a time in the process; BoltDB
+// locks the file, so a second open would wait out its timeout.
+var storeMu sync.Mutex
+
+// openSnapshots opens the BoltDB run store at STOCK_DB. It waits for
+// any other open store to close.
+//
+// STOCK_DB must be set to a file every instance shares; a store per
+// instance would lose runs between calls.
+func openSnapshots() (snapshotStore, error) {
+	path := os.Getenv("STOCK_DB")
+	if path == "" {
+		return nil, errors.New("openSnapshots: STOCK_DB env is not set")
+	}
+	storeMu.Lock()
+	bs, err := openBolt(path)
+	if err != nil {
+		storeMu.Unlock()
+		return nil, err
+	}
+	return bs, nil
+}
+
+// runIDLayout is the time layout of run IDs.
+const runIDLayout = "20060102T150405.000000000Z"
+
+// newRunID makes run IDs that sort in time order.
+func newRunID(t time.Time) string {
+	return t.UTC().Format(runIDLayout)
+}
+
+// boltStore keeps runs as JSON in a BoltDB file, keyed by run ID.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBolt(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{runsBucket, indexBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return indexRuns(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db}, nil
+}
+
+// indexRuns adds index entries for runs saved before there was an
+// index.
+func indexRuns(tx *bolt.Tx) error {
+	runs, index := tx.Bucket(runsBucket), tx.Bucket(indexBucket)
+	if runs.Stats().KeyN == index.Stats().KeyN {
+		return nil
+	}
+	missing := map[string][]byte{}
+	err := runs.ForEach(func(k, v []byte) error {
+		if index.Get(k) == nil {
+			missing[string(k)] = v
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for id, v := range missing {
+		s := snapshot{}
+		if err := json.Unmarshal(v, &s); err != nil {
+			return errors.New("snapshot: run " + id + ": " + err.Error())
+		}
+		if err := putInfo(index, &s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *snapshot) info() snapshotInfo {
+	return snapshotInfo{s.ID, s.Time, len(s.Output.Suggested), len(s.Output.Rejected)}
+}
+
+func putInfo(index *bolt.Bucket, s *snapshot) error {
+	b, err := json.Marshal(s.info())
+	if err != nil {
+		return err
+	}
+	return index.Put([]byte(s.ID), b)
+}
+
+func (bs *boltStore) save(s *snapshot) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(runsBucket).Put([]byte(s.ID), b); err != nil {
+			return err
+		}
+		return putInfo(tx.Bucket(indexBucket), s)
+	})
+}
+
+func (bs *boltStore) load(id string) (*snapshot, error) {
+	s := &snapshot{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(runsBucket).Get([]byte(id))
+		if b == nil {
+			return errors.New("snapshot: no run " + id)
+		}
+		return json.Unmarshal(b, s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (bs *boltStore) list() ([]snapshotInfo, error) {
+	infos := []snapshotInfo{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(indexBucket).ForEach(func(k, v []byte) error {
+			info := snapshotInfo{}
+			if err := json.Unmarshal(v, &info); err != nil {
+				return err
+			}
+			infos = append(infos, info)
+			return nil
+		})
+	})
+	return infos, err
+}
+
+func (bs *boltStore) close() error {
+	defer storeMu.Unlock()
+	return bs.db.Close()
+}
+
+// saveSnapshot stores a finished run and returns its ID.
+func (e *engine) saveSnapshot(data *fbaStockFiles, resp apiRespond) (string, error) {
+	store, err := openSnapshots()
+	if err != nil {
+		return "", err
+	}
+	defer store.close()
+
+	now := time.Now()
+	s := &snapshot{
+		ID:      newRunID(now),
+		Time:    now,
+		Request: e.opts,
+		Rules:   e.rules,
+		Inputs:  data.inputs,
+		Output:  resp,
+	}
+	return s.ID, store.save(s)
+}
+
+type diffRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// MinQtChange and MinProfChange set what counts as a big change.
+	MinQtChange   int     `json:"min_qt_change"`
+	MinProfChange float64 `json:"min_prof_change"`
+}
+
+// runDiff is what changed for SKUs between two runs.
+type runDiff struct {
+	From    string
+	To      string
+	New     []string
+	Dropped []droppedSKU
+	Changed []changedSKU
+}
+
+type droppedSKU struct {
+	SKU    string
+	Reason string
+}
+
+// changedSKU holds the old and new values of a SKU that moved.
+type changedSKU struct {
+	SKU     string
+	SugQt   [2]int
+	EstProf [2]float64
+	Alert   [2]string
+}
+
+// diffRuns compares the Suggested and FBARestock output of two runs.
+// Dropped SKUs carry their rejection reason from the newer run. A SKU
+// has one Changed entry however many of its values moved.
+func diffRuns(from, to *snapshot, minQtChange int, minProfChange float64) runDiff {
+	d := runDiff{From: from.ID, To: to.ID}
+	old, cur := from.Output, to.Output
+
+	for _, sku := range sortedKeys(cur.Suggested) {
+		o, ok := old.Suggested[sku]
+		if !ok {
+			d.New = append(d.New, sku)
+			continue
+		}
+		n := cur.Suggested[sku]
+		dq := n.SugQt - o.SugQt
+		if dq < 0 {
+			dq = -dq
+		}
+		if dq >= minQtChange || math.Abs(n.EstProf-o.EstProf) >= minProfChange {
+			d.Changed = append(d.Changed, changedSKU{
+				SKU:     sku,
+				SugQt:   [2]int{o.SugQt, n.SugQt},
+				EstProf: [2]float64{o.EstProf, n.EstProf},
+			})
+		}
+	}
+
+	for _, sku := range sortedKeys(old.Suggested) {
+		if _, ok := cur.Suggested[sku]; ok {
+			continue
+		}
+		reason := "not in reports"
+		if rej, ok := cur.Rejected[sku]; ok {
+			reason = rej.Reason
+		}
+		d.Dropped = append(d.Dropped, droppedSKU{sku, reason})
+	}
+
+	changed := map[string]int{}
+	for i, c := range d.Changed {
+		changed[c.SKU] = i
+	}
+	for _, sku := range sortedKeys(cur.FBARestock) {
+		o, ok := old.FBARestock[sku]
+		n := cur.FBARestock[sku]
+		if !ok || o.Alert == n.Alert {
+			continue
+		}
+		if i, ok := changed[sku]; ok {
+			d.Changed[i].Alert = [2]string{o.Alert, n.Alert}
+			continue
+		}
+		d.Changed = append(d.Changed, changedSKU{SKU: sku, Alert: [2]string{o.Alert, n.Alert}})
+	}
+	sort.SliceStable(d.Changed, func(i, j int) bool { return d.Changed[i].SKU < d.Changed[j].SKU })
+
+	return d
+}
+
+// listRuns sends back every stored run.
+func listRuns(w http.ResponseWriter, r *http.Request) {
+	store, err := openSnapshots()
+	if err != nil {
+		errLog.Println("openSnapshots:", err)
+		http.Error(w, "Error opening run store", http.StatusInternalServerError)
+		return
+	}
+	defer store.close()
+
+	infos, err := store.list()
+	if err != nil {
+		errLog.Println("list:", err)
+		http.Error(w, "Error listing runs", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(&infos)
+}
+
+// diffHandler compares two stored runs. With no IDs it compares the
+// last two.
+func diffHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errLog.Println("iouitl.ReadAll:", err)
+		http.Error(w, "Error reading request", http.StatusBadRequest)
+		return
+	}
+	dr := diffRequest{MinQtChange: 10, MinProfChange: 1}
+	if len(req) > 0 {
+		if err := json.Unmarshal(req, &dr); err != nil {
+			errLog.Println("json.Unmarshal:", err)
+			http.Error(w, "Error parsing request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	store, err := openSnapshots()
+	if err != nil {
+		errLog.Println("openSnapshots:", err)
+		http.Error(w, "Error opening run store", http.StatusInternalServerError)
+		return
+	}
+	defer store.close()
+
+	if dr.From == "" || dr.To == "" {
+		infos, err := store.list()
+		if err != nil {
+			errLog.Println("list:", err)
+			http.Error(w, "Error listing runs", http.StatusInternalServerError)
+			return
+		}
+		if len(infos) < 2 {
+			http.Error(w, "Need two runs to diff", http.StatusBadRequest)
+			return
+		}
+		dr.From, dr.To = infos[len(infos)-2].ID, infos[len(infos)-1].ID
+	}
+
+	from, err := store.load(dr.From)
+	if err != nil {
+		errLog.Println("load:", err)
+		http.Error(w, "Error finding run "+dr.From, http.StatusNotFound)
+		return
+	}
+	to, err := store.load(dr.To)
+	if err != nil {
+		errLog.Println("load:", err)
+		http.Error(w, "Error finding run "+dr.To, http.StatusNotFound)
+		return
+	}
+
+	d := diffRuns(from, to, dr.MinQtChange, dr.MinProfChange)
+	json.NewEncoder(w).Encode(&d)
+}
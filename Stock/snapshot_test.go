@@ -0,0 +1,138 @@
+package stock
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestOpenSnapshotsNeedsDB(t *testing.T) {
+	t.Setenv("STOCK_DB", "")
+	if store, err := openSnapshots(); err == nil {
+		store.close()
+		t.Fatal("openSnapshots with no STOCK_DB: want error")
+	}
+}
+
+func TestSnapshotList(t *testing.T) {
+	t.Setenv("STOCK_DB", filepath.Join(t.TempDir(), "stock.db"))
+	store, err := openSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+
+	now := time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC)
+	runs := []*snapshot{
+		{ID: newRunID(now), Time: now, Output: apiRespond{Rejected: map[string]rejection{"A": {Reason: rejNoStock}}}},
+		{ID: newRunID(now.Add(time.Hour)), Time: now.Add(time.Hour)},
+	}
+	for _, s := range runs {
+		if err := store.save(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	infos, err := store.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].ID != runs[0].ID || infos[1].ID != runs[1].ID {
+		t.Fatalf("list = %+v, want both runs in order", infos)
+	}
+	if infos[0].Rejected != 1 {
+		t.Errorf("list Rejected = %d, want 1", infos[0].Rejected)
+	}
+}
+
+func TestSnapshotIndexBackfill(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stock.db")
+	now := time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC)
+	old := snapshot{ID: newRunID(now), Time: now}
+
+	// a store from before the index had only the runs bucket.
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bk, err := tx.CreateBucket(runsBucket)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(old)
+		if err != nil {
+			return err
+		}
+		return bk.Put([]byte(old.ID), b)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("STOCK_DB", path)
+	store, err := openSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+	infos, err := store.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].ID != old.ID {
+		t.Fatalf("list = %+v, want %s", infos, old.ID)
+	}
+}
+
+func TestDiffRuns(t *testing.T) {
+	sug := func(qt int, prof float64) topSellerH {
+		return topSellerH{SugQt: qt, EstProf: prof}
+	}
+	alert := func(a string) fbaRestockH {
+		f := fbaRestockH{}
+		f.Alert = a
+		return f
+	}
+	from := &snapshot{ID: "old", Output: apiRespond{
+		Suggested: map[string]topSellerH{
+			"SAME": sug(10, 5), "QT": sug(10, 5), "PROF": sug(10, 5), "BOTH": sug(10, 5),
+			"SMALL": sug(10, 5), "GONE": sug(4, 1), "LEFT": sug(4, 1), "ALERT": sug(10, 5),
+		},
+		FBARestock: map[string]fbaRestockH{"ALERT": alert(""), "R": alert("out_of_stock")},
+	}}
+	to := &snapshot{ID: "new", Output: apiRespond{
+		Suggested: map[string]topSellerH{
+			"SAME": sug(10, 5), "QT": sug(25, 5), "PROF": sug(10, 7), "BOTH": sug(0, 9),
+			"SMALL": sug(12, 5.5), "ADDED": sug(4, 1), "ALERT": sug(30, 5),
+		},
+		FBARestock: map[string]fbaRestockH{"ALERT": alert("reorder_now"), "R": alert("")},
+		Rejected:   map[string]rejection{"GONE": {Reason: rejNoStock}},
+	}}
+
+	d := diffRuns(from, to, 10, 1)
+	if d.From != "old" || d.To != "new" {
+		t.Errorf("From, To = %s, %s", d.From, d.To)
+	}
+	if !reflect.DeepEqual(d.New, []string{"ADDED"}) {
+		t.Errorf("New = %v, want ADDED", d.New)
+	}
+	if !reflect.DeepEqual(d.Dropped, []droppedSKU{{"GONE", rejNoStock}, {"LEFT", "not in reports"}}) {
+		t.Errorf("Dropped = %+v", d.Dropped)
+	}
+	want := []changedSKU{
+		{SKU: "ALERT", SugQt: [2]int{10, 30}, EstProf: [2]float64{5, 5}, Alert: [2]string{"", "reorder_now"}},
+		{SKU: "BOTH", SugQt: [2]int{10, 0}, EstProf: [2]float64{5, 9}},
+		{SKU: "PROF", SugQt: [2]int{10, 10}, EstProf: [2]float64{5, 7}},
+		{SKU: "QT", SugQt: [2]int{10, 25}, EstProf: [2]float64{5, 5}},
+		{SKU: "R", Alert: [2]string{"out_of_stock", ""}},
+	}
+	if !reflect.DeepEqual(d.Changed, want) {
+		t.Errorf("Changed =\n%+v\nwant\n%+v", d.Changed, want)
+	}
+}
@@ -0,0 +1,401 @@
+package stock
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/OuttaLineNomad/storage"
+	"google.golang.org/api/drive/v3"
+)
+
+const (
+	// driveFolderID is the Drive folder the reports are dropped into.
+	driveFolderID = "1I0oW4tyYvZWC9a6BXh0UcymZlP61KOZh"
+
+	mimeFolder = "application/vnd.google-apps.folder"
+
+	// Drive appProperties set on archived reports: the run that used
+	// them and the folder they came from.
+	propRun  = "stockRun"
+	propFrom = "stockFrom"
+)
+
+// reportFile is one report or rules file offered by a reportSource.
+type reportFile struct {
+	Name     string
+	Modified time.Time
+	id       string
+	open     func() (io.ReadCloser, error)
+}
+
+// reportSource is where a Stock run reads its reports and rules from.
+type reportSource interface {
+	files() ([]reportFile, error)
+	// archive moves f into the subfolder named folder and records run
+	// with it in the source; restore finds the files archived by run
+	// from that record and moves them back.
+	archive(f reportFile, folder, run string) (archivedFile, error)
+	restore(run string) ([]archivedFile, error)
+	remove(f reportFile) error
+}
+
+// newSource picks the report source for a request.
+// Uploaded files win over the source named in the request.
+func newSource(p publishRequest, form *multipart.Form) (reportSource, error) {
+	if form != nil && len(form.File) > 0 {
+		return uploadSource{form}, nil
+	}
+
+	switch p.Source {
+	case "", "drive":
+		return newDriveSource(driveFolderID)
+	case "dir":
+		dir, err := stockDir(p.Dir)
+		if err != nil {
+			return nil, errors.New("newSource: " + err.Error())
+		}
+		return dirSource{dir}, nil
+	}
+	return nil, errors.New("newSource: unknown source " + p.Source)
+}
+
+// stockDir is the report dir for the dir source: STOCK_DIR, or sub
+// under it. sub comes from the request so it may not leave STOCK_DIR.
+func stockDir(sub string) (string, error) {
+	root := os.Getenv("STOCK_DIR")
+	if root == "" {
+		return "", errors.New("dir source needs STOCK_DIR env")
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if filepath.IsAbs(sub) {
+		return "", errors.New("dir " + sub + " must be relative to STOCK_DIR")
+	}
+	dir := filepath.Join(root, sub)
+	if !inside(root, dir) {
+		return "", errors.New("dir " + sub + " is outside STOCK_DIR")
+	}
+	// a symlink in STOCK_DIR could still point outside it.
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	if !inside(realRoot, realDir) {
+		return "", errors.New("dir " + sub + " is outside STOCK_DIR")
+	}
+	return dir, nil
+}
+
+// inside tells if path is dir or under it. Both must be clean.
+func inside(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// driveSource reads reports from a Google Drive folder.
+type driveSource struct {
+	s        *storage.Storage
+	folderID string
+}
+
+func newDriveSource(folderID string) (*driveSource, error) {
+	s, err := storage.NewGoogle()
+	if err != nil {
+		return nil, err
+	}
+	return &driveSource{s, folderID}, nil
+}
+
+func (d *driveSource) files() ([]reportFile, error) {
+	query := `'` + d.folderID + `' in parents and trashed = false and (mimeType = 'text/csv' or mimeType = 'application/json')`
+	list, err := d.s.Drive.Files.List().Q(query).Fields("files(id,name,modifiedTime)").Do()
+	if err != nil {
+		return nil, err
+	}
+
+	filz := []reportFile{}
+	for _, f := range list.Files {
+		mod, _ := time.Parse(time.RFC3339, f.ModifiedTime)
+		id := f.Id
+		filz = append(filz, reportFile{
+			Name:     f.Name,
+			Modified: mod,
+			id:       id,
+			open: func() (io.ReadCloser, error) {
+				res, err := d.s.Drive.GetFile(id)
+				if err != nil {
+					return nil, err
+				}
+				return res.Body, nil
+			},
+		})
+	}
+	return filz, nil
+}
+
+func (d *driveSource) archive(f reportFile, folder, run string) (archivedFile, error) {
+	sub, err := d.subfolder(folder)
+	if err != nil {
+		return archivedFile{}, err
+	}
+	_, err = d.s.Drive.Files.Update(f.id, &drive.File{
+		Description:   "Used by FBA Stock run " + run,
+		AppProperties: map[string]string{propRun: run, propFrom: d.folderID},
+	}).
+		AddParents(sub).
+		RemoveParents(d.folderID).
+		Do()
+	if err != nil {
+		return archivedFile{}, err
+	}
+	return archivedFile{Name: f.Name, ID: f.id, From: d.folderID, To: sub}, nil
+}
+
+// subfolder finds the folder called name in the report folder, making
+// it if it is not there.
+func (d *driveSource) subfolder(name string) (string, error) {
+	query := `'` + d.folderID + `' in parents and trashed = false and mimeType = '` + mimeFolder + `' and name = '` + name + `'`
+	list, err := d.s.Drive.Files.List().Q(query).Fields("files(id)").Do()
+	if err != nil {
+		return "", err
+	}
+	if len(list.Files) > 0 {
+		return list.Files[0].Id, nil
+	}
+	f, err := d.s.Drive.Files.Create(&drive.File{
+		Name:     name,
+		MimeType: mimeFolder,
+		Parents:  []string{d.folderID},
+	}).Do()
+	if err != nil {
+		return "", err
+	}
+	return f.Id, nil
+}
+
+// restore finds run's files by their appProperties and moves them back
+// to the folder they came from. Restored files keep a note of the run
+// so a second restore finds nothing.
+func (d *driveSource) restore(run string) ([]archivedFile, error) {
+	query := `appProperties has { key='` + propRun + `' and value='` + run + `' } and trashed = false`
+	list, err := d.s.Drive.Files.List().Q(query).Fields("files(id,name,parents,appProperties)").Do()
+	if err != nil {
+		return nil, err
+	}
+
+	restored := []archivedFile{}
+	for _, f := range list.Files {
+		a := archivedFile{Name: f.Name, ID: f.Id, From: f.AppProperties[propFrom], Run: run}
+		if a.From == "" {
+			a.From = d.folderID
+		}
+		if len(f.Parents) > 0 {
+			a.To = f.Parents[0]
+		}
+		_, err := d.s.Drive.Files.Update(f.Id, &drive.File{
+			Description:   "Restored from FBA Stock run " + run,
+			AppProperties: map[string]string{propRun: "restored " + run},
+		}).
+			AddParents(a.From).
+			RemoveParents(a.To).
+			Do()
+		if err != nil {
+			return restored, errors.New(f.Name + `: ` + err.Error())
+		}
+		restored = append(restored, a)
+	}
+	return restored, nil
+}
+
+func (d *driveSource) remove(f reportFile) error {
+	return d.s.Drive.Files.Delete(f.id).Do()
+}
+
+// dirSource reads reports from a local directory, for offline reruns.
+type dirSource struct {
+	dir string
+}
+
+func (d dirSource) files() ([]reportFile, error) {
+	infos, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	filz := []reportFile{}
+	for _, info := range infos {
+		ext := strings.ToLower(filepath.Ext(info.Name()))
+		if info.IsDir() || ext != ".csv" && ext != ".json" {
+			continue
+		}
+		path := filepath.Join(d.dir, info.Name())
+		filz = append(filz, reportFile{
+			Name:     info.Name(),
+			Modified: info.ModTime(),
+			id:       path,
+			open: func() (io.ReadCloser, error) {
+				return os.Open(path)
+			},
+		})
+	}
+	return filz, nil
+}
+
+// archive moves f into archive/folder/<run> under the report dir and
+// adds it to the run's manifest, archive/folder/<run>.json, so runs on
+// the same day keep their own copies. Paths in the manifest are
+// relative to the report dir.
+func (d dirSource) archive(f reportFile, folder, run string) (archivedFile, error) {
+	sub := filepath.Join("archive", folder, run)
+	if err := os.MkdirAll(filepath.Join(d.dir, sub), 0755); err != nil {
+		return archivedFile{}, err
+	}
+	a := archivedFile{
+		Name: f.Name,
+		ID:   f.id,
+		From: filepath.Base(f.id),
+		To:   filepath.Join(sub, filepath.Base(f.id)),
+		Run:  run,
+	}
+	if err := moveNew(f.id, filepath.Join(d.dir, a.To)); err != nil {
+		return archivedFile{}, err
+	}
+
+	manifest := filepath.Join(d.dir, "archive", folder, run+".json")
+	files := []archivedFile{}
+	err := readJSON(manifest, &files)
+	if err == nil || os.IsNotExist(err) {
+		files = append(files, a)
+		err = writeJSON(manifest, files)
+	}
+	if err != nil {
+		// put it back so no file is archived without a record.
+		os.Rename(filepath.Join(d.dir, a.To), f.id)
+		return archivedFile{}, err
+	}
+	return a, nil
+}
+
+// restore moves back the files in run's manifests and removes them.
+// Nothing is moved when a report of the same name is back in the report
+// dir, so a newer report is never replaced by an older one.
+func (d dirSource) restore(run string) ([]archivedFile, error) {
+	manifests, err := filepath.Glob(filepath.Join(d.dir, "archive", "*", run+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([][]archivedFile, len(manifests))
+	for i, m := range manifests {
+		if err := readJSON(m, &runs[i]); err != nil {
+			return nil, err
+		}
+		for _, a := range runs[i] {
+			from, to := filepath.Join(d.dir, a.From), filepath.Join(d.dir, a.To)
+			if !inside(d.dir, from) || !inside(d.dir, to) {
+				return nil, errors.New(a.Name + `: outside the report dir`)
+			}
+			if _, err := os.Lstat(from); err == nil {
+				return nil, errors.New(a.Name + `: ` + a.From + ` is in the report dir`)
+			}
+		}
+	}
+
+	restored := []archivedFile{}
+	for i, m := range manifests {
+		for _, a := range runs[i] {
+			if err := moveNew(filepath.Join(d.dir, a.To), filepath.Join(d.dir, a.From)); err != nil {
+				return restored, errors.New(a.Name + `: ` + err.Error())
+			}
+			restored = append(restored, a)
+		}
+		if err := os.Remove(m); err != nil {
+			return restored, err
+		}
+		// the run's folder is left when it holds anything else.
+		os.Remove(filepath.Join(filepath.Dir(m), run))
+	}
+	return restored, nil
+}
+
+// moveNew renames from to to, failing rather than replacing a file
+// already at to.
+func moveNew(from, to string) error {
+	if _, err := os.Lstat(to); err == nil {
+		return errors.New(to + ` already exists`)
+	}
+	return os.Rename(from, to)
+}
+
+func readJSON(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func writeJSON(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+func (d dirSource) remove(f reportFile) error {
+	return os.Remove(f.id)
+}
+
+// uploadSource reads reports uploaded with a multipart Stock request.
+// The files all take the request time, so an upload can't have two
+// rules files or Restock Reports.
+type uploadSource struct {
+	form *multipart.Form
+}
+
+func (u uploadSource) files() ([]reportFile, error) {
+	filz := []reportFile{}
+	now := time.Now()
+	for _, field := range sortedKeys(u.form.File) {
+		for _, h := range u.form.File[field] {
+			fh := h
+			filz = append(filz, reportFile{
+				Name:     fh.Filename,
+				Modified: now,
+				id:       fh.Filename,
+				open: func() (io.ReadCloser, error) {
+					return fh.Open()
+				},
+			})
+		}
+	}
+	return filz, nil
+}
+
+// archive and remove do nothing and there is nothing to restore;
+// uploads only live for the request.
+func (u uploadSource) archive(f reportFile, folder, run string) (archivedFile, error) {
+	return archivedFile{}, nil
+}
+
+func (u uploadSource) restore(run string) ([]archivedFile, error) {
+	return nil, errors.New("uploaded reports are not kept to restore")
+}
+
+func (u uploadSource) remove(f reportFile) error {
+	return nil
+}
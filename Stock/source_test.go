@@ -0,0 +1,328 @@
+package stock
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reportFixtures are a small set of reports and rules, as a run would
+// find them in its folder.
+var reportFixtures = map[string]string{
+	"rules.json": `{"Topseller": 1, "Profit": 0, "Fees": {"FBAClass": {"default": 3}}}`,
+	"CA data.csv": "Report Start Date,Report End Date,Title,Qty Sold,GMV,SKU\n" +
+		"2018-01-01,2018-01-31,Red Shirt,12,240.00,RED-1\n" +
+		"2018-01-01,2018-01-31,Blue Shirt,3,60.00,BLUE-1\n",
+	"Restock Report.csv": "SKU,Inbound,Alert,Recommended Order Quantity,Recommended Order Date\n" +
+		"RED-1,2,out_of_stock,10,2018-02-01\n" +
+		"BLUE-1,0,,0,2018-02-01\n",
+	"Business Report.csv": "(Parent) ASIN,(Child) ASIN,SKU,Sessions,Page Views,Buy Box Percentage,Unit Session Percentage,Units Ordered,Ordered Product Sales\n" +
+		"B01,B02,RED-1,40,55,98,20,8,160\n",
+	"notes.txt": "not a report",
+}
+
+// writeFixtures writes files into dir.
+func writeFixtures(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, body := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func fileNames(filz []reportFile) []string {
+	names := []string{}
+	for _, f := range filz {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestDirSourceFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtures(t, dir, reportFixtures)
+	if err := os.Mkdir(filepath.Join(dir, "archive.csv"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	filz, err := dirSource{dir}.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fileNames(filz)
+	want := []string{"Business Report.csv", "CA data.csv", "Restock Report.csv", "rules.json"}
+	if len(got) != len(want) {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("files = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestDirSourceArchiveRestore(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtures(t, dir, map[string]string{"CA data.csv": reportFixtures["CA data.csv"]})
+	src := dirSource{dir}
+	filz, err := src.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := newRunID(time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC))
+	if _, err := src.archive(filz[0], "2018-02-01", run); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{filepath.Join(run, "CA data.csv"), run + ".json"} {
+		if _, err := os.Stat(filepath.Join(dir, "archive", "2018-02-01", name)); err != nil {
+			t.Fatalf("archived %s: %v", name, err)
+		}
+	}
+	if left, _ := src.files(); len(left) != 0 {
+		t.Fatalf("files after archive = %v, want none", fileNames(left))
+	}
+
+	if got, err := src.restore("20180101T000000.000000000Z"); err != nil || len(got) != 0 {
+		t.Fatalf("restore of another run = %v, %v; want nothing", got, err)
+	}
+	restored, err := src.restore(run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 1 || restored[0].Name != "CA data.csv" {
+		t.Fatalf("restore = %+v, want CA data.csv", restored)
+	}
+	if back, _ := src.files(); len(back) != 1 {
+		t.Fatalf("files after restore = %v, want CA data.csv", fileNames(back))
+	}
+	if again, _ := src.restore(run); len(again) != 0 {
+		t.Fatalf("second restore = %+v, want nothing", again)
+	}
+}
+
+func TestDirSourceArchiveSameDay(t *testing.T) {
+	dir := t.TempDir()
+	src := dirSource{dir}
+	runs := []string{
+		newRunID(time.Date(2018, 2, 1, 9, 0, 0, 0, time.UTC)),
+		newRunID(time.Date(2018, 2, 1, 15, 0, 0, 0, time.UTC)),
+	}
+	for _, run := range runs {
+		writeFixtures(t, dir, map[string]string{"CA data.csv": run})
+		filz, err := src.files()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := src.archive(filz[0], "2018-02-01", run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, run := range runs {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "archive", "2018-02-01", run, "CA data.csv"))
+		if err != nil || string(b) != run {
+			t.Errorf("run %s archived %q, %v; want its own report", run, b, err)
+		}
+	}
+	writeFixtures(t, dir, map[string]string{"CA data.csv": "again"})
+	again, _ := src.files()
+	if _, err := src.archive(again[0], "2018-02-01", runs[1]); err == nil {
+		t.Fatal("archive over an archived report: want error")
+	}
+	if err := os.Remove(filepath.Join(dir, "CA data.csv")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := src.restore(runs[0]); err != nil {
+		t.Fatal(err)
+	}
+	// the first run's report is back, so the second can't take its place.
+	if got, err := src.restore(runs[1]); err == nil || len(got) != 0 {
+		t.Fatalf("restore over a report = %+v, %v; want error", got, err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "CA data.csv")); string(b) != runs[0] {
+		t.Fatalf("CA data.csv = %q, want run %s", b, runs[0])
+	}
+
+	if err := os.Remove(filepath.Join(dir, "CA data.csv")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := src.restore(runs[1]); err != nil || len(got) != 1 {
+		t.Fatalf("restore = %+v, %v; want CA data.csv", got, err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "CA data.csv")); string(b) != runs[1] {
+		t.Fatalf("CA data.csv = %q, want run %s", b, runs[1])
+	}
+}
+
+// uploadForm is files uploaded as the reports field of a Stock request.
+func uploadForm(t *testing.T, files map[string]string) *multipart.Form {
+	t.Helper()
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for name, data := range files {
+		fw, err := mw.CreateFormFile("reports", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(data))
+	}
+	mw.Close()
+	form, err := multipart.NewReader(body, mw.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return form
+}
+
+func TestUploadSourceFiles(t *testing.T) {
+	src, err := newSource(publishRequest{Source: "dir"}, uploadForm(t, reportFixtures))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := src.(uploadSource); !ok {
+		t.Fatalf("newSource with uploads = %T, want uploadSource", src)
+	}
+	filz, err := src.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filz) != len(reportFixtures) {
+		t.Fatalf("files = %v, want %d", fileNames(filz), len(reportFixtures))
+	}
+	for _, f := range filz {
+		r, err := f.open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(r)
+		r.Close()
+		if string(b) != reportFixtures[f.Name] {
+			t.Errorf("%s = %q, want %q", f.Name, b, reportFixtures[f.Name])
+		}
+	}
+}
+
+func TestNewSourceDir(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "rerun"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir  string
+		want string
+		ok   bool
+	}{
+		{"", root, true},
+		{"rerun", filepath.Join(root, "rerun"), true},
+		{"rerun/../rerun", filepath.Join(root, "rerun"), true},
+		{"..", "", false},
+		{"../etc", "", false},
+		{"rerun/../../etc", "", false},
+		{outside, "", false},
+		{"/etc", "", false},
+		{"link", "", false},
+		{"missing", "", false},
+	}
+	t.Setenv("STOCK_DIR", root)
+	for _, tt := range tests {
+		src, err := newSource(publishRequest{Source: "dir", Dir: tt.dir}, nil)
+		if (err == nil) != tt.ok {
+			t.Errorf("newSource dir %q: err = %v, want ok %v", tt.dir, err, tt.ok)
+			continue
+		}
+		if err == nil && src.(dirSource).dir != tt.want {
+			t.Errorf("newSource dir %q = %s, want %s", tt.dir, src.(dirSource).dir, tt.want)
+		}
+	}
+
+	t.Setenv("STOCK_DIR", "")
+	if _, err := newSource(publishRequest{Source: "dir", Dir: root}, nil); err == nil {
+		t.Error("newSource dir with no STOCK_DIR: want error")
+	}
+}
+
+func TestGetReportsDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtures(t, dir, reportFixtures)
+
+	stock, err := getReports(dirSource{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stock.rules == nil || stock.rules.Topseller != 1 {
+		t.Errorf("rules = %+v, want Topseller 1", stock.rules)
+	}
+	if len(stock.CAData) != 2 || stock.CAData["RED-1"].QtySold != 12 {
+		t.Errorf("CAData = %+v, want RED-1 and BLUE-1", stock.CAData)
+	}
+	if len(stock.FBARestock) != 1 || stock.FBARestock["RED-1"].RecQt != 10 {
+		t.Errorf("FBARestock = %+v, want RED-1 only", stock.FBARestock)
+	}
+	if len(stock.fbaStock) != 2 {
+		t.Errorf("fbaStock has %d SKUs, want 2", len(stock.fbaStock))
+	}
+	if v := stock.AMZViews["RED-1"]; v.Sessions != 40 || v.UnitsOrdered != 8 {
+		t.Errorf("AMZViews RED-1 = %+v", v)
+	}
+	if len(stock.Files.Used) != 4 {
+		t.Errorf("Used = %+v, want 4 files", stock.Files.Used)
+	}
+	// the rules file is read but left in the source.
+	if got := fileNames(stock.used); len(got) != 3 || got[0] != "Business Report.csv" || got[2] != "Restock Report.csv" {
+		t.Errorf("used = %v, want the three reports", got)
+	}
+	// the dir source doesn't offer notes.txt at all.
+	if len(stock.Files.Ignored) != 0 {
+		t.Errorf("Ignored = %+v, want none", stock.Files.Ignored)
+	}
+}
+
+func TestGetReportsMissing(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtures(t, dir, map[string]string{"CA data.csv": reportFixtures["CA data.csv"]})
+	if _, err := getReports(dirSource{dir}); err == nil {
+		t.Fatal("getReports with only CA data: want error")
+	}
+}
+
+func TestGetReportsUploadSameType(t *testing.T) {
+	tests := []struct {
+		name  string
+		extra map[string]string
+	}{
+		{"two rules files", map[string]string{"rules 2.json": reportFixtures["rules.json"]}},
+		{"two Restock Reports", map[string]string{"Restock Report 2.csv": "SKU,Inbound,Alert,Recommended Order Quantity,Recommended Order Date\n" +
+			"RED-1,0,,4,2018-02-01\n"}},
+	}
+	for _, tt := range tests {
+		files := map[string]string{}
+		for name, data := range reportFixtures {
+			files[name] = data
+		}
+		for name, data := range tt.extra {
+			files[name] = data
+		}
+		_, err := getReports(uploadSource{uploadForm(t, files)})
+		if err == nil || !strings.Contains(err.Error(), "of the same time") {
+			t.Errorf("%s: err = %v, want same time error", tt.name, err)
+		}
+	}
+
+	if _, err := getReports(uploadSource{uploadForm(t, reportFixtures)}); err != nil {
+		t.Errorf("one of each: %v", err)
+	}
+}
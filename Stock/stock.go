@@ -8,31 +8,23 @@ import (
 	"io/ioutil"
 	"log"
 	"math"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/WedgeNix/excel"
-
-	"github.com/OuttaLineNomad/skuvault"
 	"github.com/OuttaLineNomad/skuvault/products"
-
-	"github.com/OuttaLineNomad/storage"
 )
 
 var (
+	// filename hints for detectReport.
 	caData     = regexp.MustCompile(`([Dd][Aa][Tt][Aa]|[Cc][Aa]|[Oo][Rr][Dd][Ee][Rr])[_ -]?([Dd][Aa][Tt][Aa]|[Cc][Aa]|[Oo][Rr][Dd][Ee][Rr])`)
 	amzViews   = regexp.MustCompile(`[Bb]usiness[ _-]?[Rr]eport`)
 	fbaRestock = regexp.MustCompile(`[Rr]estock[- _][Rr]eport`)
 
-	sugPriceUser = false
-
-	rules = &rulesFile{}
-
-	delScrCH = make(chan bool)
-	errCH    = make(chan error)
 	// logs
 	stdLog = log.New(os.Stdout, "FBAStock: ", 0)
 	errLog = log.New(os.Stderr, "FBAStock Error: ", 0)
@@ -50,6 +42,13 @@ type svData struct {
 	AvailableQt int
 	SvTitle     string
 	InboundQt   int
+	RetailPrice float64
+	// for the fee engine; pounds and inches.
+	Weight   float64
+	Length   float64
+	Width    float64
+	Height   float64
+	Category string
 }
 
 type svDatas map[string]svData
@@ -58,6 +57,31 @@ type fbaStockFiles struct {
 	CAData     map[string]topSellerH
 	FBARestock map[string]fbaRestockH
 	AMZViews   map[string]amzViewsH
+	Files      reportUsage
+	Rejected   map[string]rejection
+	// rules is the rules file found with the reports.
+	rules *rulesFile
+	// used are the report files read, for consumeSource.
+	used []reportFile
+	// budget is how allocateBudget spent the request budget.
+	budget *budgetResult
+	// rows of each report file used, kept for the run snapshot.
+	inputs map[string][][]string
+	// every Restock Report row, alert or not, for the FBA position.
+	fbaStock map[string]fbaRestockF
+	// CA sales by date range for the daily spread.
+	periods map[string][]salesPeriod
+	// days of sales each SKU's CA data covers, gaps between date
+	// ranges left out.
+	caDays map[string]float64
+	// every CA row before the Topseller cut, the parent ASIN of every
+	// Business Report row, sessions or not, and parent totals by child
+	// SKU, for rollupParents.
+	caRows   map[string]top
+	parentOf map[string]string
+	parents  map[string]parentRollup
+	// weekly indices by brand or class for the seasonal forecast.
+	seasons map[string]*seasonIndex
 }
 
 type rulesFile struct {
@@ -66,9 +90,32 @@ type rulesFile struct {
 	Fees      struct {
 		FeePercentage float64
 		FBAClass      map[string]float64
+		// Table is the built in fee data file for getFees, see feeTables.
+		Table string
 	}
 	DaysCoverd      int
 	SalesMultiplier float64
+	// QtMode picks the getSugQt formula: "coverage" or "safety". Both
+	// take SKU Vault inbound off the target. Safety also takes off FBA
+	// available, reserved and inbound units; coverage only does with
+	// NetFBA.
+	QtMode       string
+	NetFBA       bool
+	LeadTimeDays int
+	ServiceLevel float64
+	// Forecast picks flat or seasonal demand for getSugQt.
+	Forecast    string
+	Seasonality seasonRules
+	// Pricing sets up suggest_price.
+	Pricing pricingRules
+	// Capacity caps the cubic feet sent to FBA.
+	Capacity capacityRules
+	// overrides, see resolve for precedence.
+	Classes      map[string]ruleOverride
+	Brands       map[string]ruleOverride
+	BrandClasses map[string]ruleOverride
+	// Inbound sets up the inbound plan file format.
+	Inbound inboundRules
 }
 
 type fbaRestockH struct {
@@ -77,11 +124,14 @@ type fbaRestockH struct {
 }
 
 type fbaRestockF struct {
-	SKU     string
-	Inbound int
-	Alert   string
-	RecQt   int
-	RecDate time.Time
+	SKU           string
+	Inbound       int
+	Alert         string
+	RecQt         int
+	RecDate       time.Time
+	Available     int `report:"optional"`
+	Reserved      int `report:"optional"`
+	Unfulfillable int `report:"optional"`
 }
 
 type amzViewsH struct {
@@ -108,23 +158,50 @@ type top struct {
 type topSellerH struct {
 	top
 	svData
-	Fees     float64
+	Fees     feeBreakdown
 	EstPrice float64
 	SugPrice float64
-	EstProf  float64
-	SugQt    int
-	Restock  bool
+	// SugProf and PriceBasis go with SugPrice; see suggestPrice.
+	SugProf    float64
+	PriceBasis string
+	EstProf    float64
+	SugQt      int
+	SafetyQt   int
+	FBAQt      int
+	Restock    bool
+	Override   string
+	Cuts       qtCuts        `json:",omitempty"`
+	Trace      *skuTrace     `json:",omitempty"`
+	Parent     *parentRollup `json:",omitempty"`
 	amzViewsH
 }
 
 type apiRespond struct {
 	Suggested  map[string]topSellerH  `json:"Suggested"`
 	FBARestock map[string]fbaRestockH `json:"FBARestock"`
+	Files      reportUsage            `json:"Files"`
+	Rejected   map[string]rejection   `json:"Rejected"`
+	SavedFile  string                 `json:"SavedFile,omitempty"`
+	Archived   []archivedFile         `json:"Archived,omitempty"`
+	Budget     *budgetResult          `json:"Budget,omitempty"`
+	RunID      string                 `json:"RunID,omitempty"`
 }
 
 type publishRequest struct {
 	SuggestPrice bool `json:"suggest_price"`
 	DeleteSource bool `json:"delete_source"`
+	HardDelete   bool `json:"hard_delete"`
+	Explain      bool `json:"explain"`
+	ParentRollup bool `json:"parent_rollup"`
+	// Simulate runs each candidate rules patch by name instead of a
+	// normal run; see simulate.
+	Simulate    map[string]json.RawMessage `json:"simulate,omitempty"`
+	Format      string                     `json:"format"`
+	SaveToDrive bool                       `json:"save_to_drive"`
+	Source      string                     `json:"source"`
+	Dir         string                     `json:"dir"`
+	Budget      float64                    `json:"budget"`
+	BudgetGoal  string                     `json:"budget_goal"`
 }
 
 type brandReg map[string]*regexp.Regexp
@@ -134,15 +211,51 @@ func Stock(w http.ResponseWriter, r *http.Request) {
 	if err := authRequest(r); err != nil {
 		errLog.Println("authRequest:", err)
 		http.Error(w, "Error authorizing request", http.StatusUnauthorized)
+		return
 	}
 
-	// Read the request body.
-	req, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		errLog.Println("iouitl.ReadAll:", err)
-		http.Error(w, "Error reading request", http.StatusBadRequest)
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/rules/validate"):
+		logP("validating rules...")
+		validateRules(w, r)
+		return
+	case strings.HasSuffix(r.URL.Path, "/runs/diff"):
+		logP("diffing runs...")
+		diffHandler(w, r)
+		return
+	case strings.HasSuffix(r.URL.Path, "/runs/restore"):
+		logP("restoring archived reports...")
+		restoreHandler(w, r)
+		return
+	case strings.HasSuffix(r.URL.Path, "/runs"):
+		logP("listing runs...")
+		listRuns(w, r)
 		return
 	}
+
+	// Read the request body; reports may be uploaded with it.
+	var form *multipart.Form
+	var req []byte
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			errLog.Println("r.ParseMultipartForm:", err)
+			http.Error(w, "Error reading request", http.StatusBadRequest)
+			return
+		}
+		form = r.MultipartForm
+		req = []byte(r.FormValue("request"))
+		if len(req) == 0 {
+			req = []byte("{}")
+		}
+	} else {
+		req, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			errLog.Println("iouitl.ReadAll:", err)
+			http.Error(w, "Error reading request", http.StatusBadRequest)
+			return
+		}
+	}
 	logP(string(req))
 	// Parse json into struct
 	p := publishRequest{}
@@ -152,44 +265,150 @@ func Stock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sugPriceUser = p.SuggestPrice
+	if err := checkBudget(&p); err != nil {
+		errLog.Println("checkBudget:", err)
+		http.Error(w, "Error bad budget", http.StatusBadRequest)
+		return
+	}
 
-	// DeleteSouce does not work because scope for drive is not working.
-	if p.DeleteSource {
-		errLog.Println("delete_source is future feature does not work now; no files will be deleted")
-		p.DeleteSource = false
+	format, err := exportFormat(p, r)
+	if err != nil {
+		errLog.Println("exportFormat:", err)
+		http.Error(w, "Error unknown format", http.StatusBadRequest)
+		return
 	}
 
-	logP("pulling all drive files...")
-	data, err := getDrive()
+	src, err := newSource(p, form)
 	if err != nil {
-		errLog.Println("getDrive:", err)
+		errLog.Println("newSource:", err)
+		http.Error(w, "Error opening report source", http.StatusBadRequest)
+		return
+	}
+
+	e := newEngine(p)
+
+	logP("pulling all report files...")
+	reports, err := getReports(src)
+	if err != nil {
+		errLog.Println("getReports:", err)
 		http.Error(w, "Server error reading files", http.StatusInternalServerError)
 		return
 	}
 
-	logP("files successfully pulled now getting suggestions...")
-	err = data.getSuggestion()
+	e, err = e.withRules(reports.rules)
 	if err != nil {
-		errLog.Println("getSuggestion:", err)
+		errLog.Println("withRules:", err)
 		http.Error(w, "Error creating report", http.StatusInternalServerError)
 		return
 	}
 
-	logP("adding sugested restock...")
-	delScrCH <- p.DeleteSource
-	if err := <-errCH; err != nil {
-		errLog.Println("deleteSource:", err)
-		http.Error(w, "Error deleting source", http.StatusInternalServerError)
+	if len(p.Simulate) > 0 {
+		logP("simulating rule sets...")
+		sim, err := e.simulate(reports, p.Simulate)
+		if err != nil {
+			errLog.Println("simulate:", err)
+			http.Error(w, "Error simulating rules", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(&sim)
+		logP("sent!")
+		return
+	}
+
+	logP("files successfully pulled now getting suggestions...")
+	data, err := e.run(reports)
+	if err != nil {
+		errLog.Println("getSuggestion:", err)
+		http.Error(w, "Error creating report", http.StatusInternalServerError)
 		return
 	}
 
+	logP("adding sugested restock...")
 	newResp := apiRespond{
 		Suggested:  data.CAData,
 		FBARestock: data.FBARestock,
+		Files:      data.Files,
+		Rejected:   data.Rejected,
+		Budget:     data.budget,
+	}
+
+	newResp.RunID, err = e.saveSnapshot(data, newResp)
+	if err != nil {
+		errLog.Println("saveSnapshot:", err)
+		// reports may only leave the source for a run that is on record.
+		if consumeMode(p) != consumeKeep {
+			http.Error(w, "Error saving run", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var book []byte
+	if format == formatXLSX || p.SaveToDrive && format != formatInbound {
+		book, err = newResp.writeXLSX()
+		if err != nil {
+			errLog.Println("writeXLSX:", err)
+			http.Error(w, "Error creating workbook", http.StatusInternalServerError)
+			return
+		}
 	}
 
-	json.NewEncoder(w).Encode(&newResp)
+	var plan []byte
+	if format == formatInbound {
+		lines, short := inboundPlan(e.rules.Inbound, newResp.Suggested)
+		if len(short) > 0 {
+			logP("less than a case, left off inbound plan:", strings.Join(short, ", "))
+			w.Header().Set("X-Inbound-Short", strings.Join(short, ","))
+		}
+		plan = writeInbound(e.rules.Inbound, lines)
+	}
+
+	if p.SaveToDrive {
+		logP("saving to drive...")
+		name, mime, b := "FBA Stock "+time.Now().Format("2006-01-02 1504")+".xlsx", mimeXLSX, book
+		if format == formatInbound {
+			name, mime, b = "FBA Inbound Plan "+time.Now().Format("2006-01-02 1504")+".txt", mimeInbound, plan
+		}
+		newResp.SavedFile, err = saveToDrive(src, name, mime, b)
+		if err != nil {
+			errLog.Println("saveToDrive:", err)
+			http.Error(w, "Error saving workbook to drive", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var sheet []byte
+	if format == formatCSV {
+		sheet, err = newResp.writeCSV()
+		if err != nil {
+			errLog.Println("writeCSV:", err)
+			http.Error(w, "Error creating csv", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Only now is the output made, so the reports can leave the source.
+	if err := e.consume(src, data.used, &newResp); err != nil {
+		errLog.Println("consumeSource:", err)
+		http.Error(w, "Error archiving source", http.StatusInternalServerError)
+		return
+	}
+
+	switch format {
+	case formatCSV:
+		w.Header().Set("Content-Type", mimeCSV)
+		w.Header().Set("Content-Disposition", `attachment; filename="fba-stock.csv"`)
+		w.Write(sheet)
+	case formatXLSX:
+		w.Header().Set("Content-Type", mimeXLSX)
+		w.Header().Set("Content-Disposition", `attachment; filename="fba-stock.xlsx"`)
+		w.Write(book)
+	case formatInbound:
+		w.Header().Set("Content-Type", mimeInbound)
+		w.Header().Set("Content-Disposition", `attachment; filename="fba-inbound-plan.txt"`)
+		w.Write(plan)
+	default:
+		json.NewEncoder(w).Encode(&newResp)
+	}
 	logP("sent!")
 }
 
@@ -219,6 +438,8 @@ func (filz *fbaStockFiles) createReport() ([]byte, error) {
 	newResp := apiRespond{
 		Suggested:  filz.CAData,
 		FBARestock: filz.FBARestock,
+		Files:      filz.Files,
+		Rejected:   filz.Rejected,
 	}
 
 	b, err := json.Marshal(newResp)
@@ -228,85 +449,6 @@ func (filz *fbaStockFiles) createReport() ([]byte, error) {
 	return b, nil
 }
 
-func getDrive() (*fbaStockFiles, error) {
-	var fbar []fbaRestockF
-	var amzv []amzViewsH
-	var tops []top
-	s, err := storage.NewGoogle()
-	if err != nil {
-		return nil, err
-	}
-
-	filz, err := s.Drive.GetFileIDs("1I0oW4tyYvZWC9a6BXh0UcymZlP61KOZh", "text/csv", "application/json")
-	if err != nil {
-		return nil, err
-	}
-
-	if len(filz) != 4 {
-		errLog.Println("file cound:", len(filz))
-		return nil, errors.New(`getDrive: not enough/to manny files - there must be 4 files in this folder`)
-	}
-
-	go deleteSource(s, filz)
-
-	stock := &fbaStockFiles{}
-	for _, id := range filz {
-		if id.Name == `rules.json` {
-			getSettings(id.ID, s.Drive)
-			continue
-		}
-
-		csvData, err := getData(id.ID, s.Drive)
-		if err != nil {
-			return nil, err
-		}
-		f := excel.File{
-			Sheets: [][][]string{csvData},
-		}
-
-		if caData.MatchString(id.Name) {
-			if err = f.Unmarshal(&tops); err != nil {
-				return nil, err
-			}
-			stock.mapDataCA(tops)
-			continue
-		}
-
-		if fbaRestock.MatchString(id.Name) {
-			if err = f.Unmarshal(&fbar); err != nil {
-				return nil, err
-			}
-			stock.mapDataRestock(fbar)
-			continue
-		}
-
-		if amzViews.MatchString(id.Name) {
-			if err = f.Unmarshal(&amzv); err != nil {
-				return nil, err
-			}
-			stock.mapDataView(amzv)
-			continue
-		}
-		return nil, errors.New(`getData: filename ` + id.Name + ` in folder not recognized`)
-	}
-
-	return stock, nil
-}
-
-func deleteSource(s *storage.Storage, files []storage.DriveFile) {
-	if <-delScrCH {
-		logP("deleating source files.")
-		for _, f := range files {
-			err := s.Drive.Files.Delete(f.ID).Do()
-			if err != nil {
-				errCH <- err
-				return
-			}
-		}
-	}
-	errCH <- nil
-}
-
 func (filz *fbaStockFiles) addToFBAReStk(skus svDatas) svDatas {
 	svD := make(svDatas)
 	newFBA := make(map[string]fbaRestockH)
@@ -325,29 +467,82 @@ func (filz *fbaStockFiles) addToFBAReStk(skus svDatas) svDatas {
 	return svD
 }
 
-func (filz *fbaStockFiles) getSuggestion() error {
-	costMap, err := filz.getSvData()
+func (e *engine) getSuggestion(filz *fbaStockFiles) error {
+	costMap, err := e.getSvData(filz)
 	if err != nil {
 		return err
 	}
 
+	if e.rules.Forecast == forecastSeasonal {
+		if err := filz.addHistory(e.rules.Seasonality.Snapshots); err != nil {
+			return err
+		}
+		filz.seasons = fitSeasons(filz.periods, costMap, e.rules.Seasonality.GroupBy)
+	}
+
+	if filz.Rejected == nil {
+		filz.Rejected = make(map[string]rejection)
+	}
+	for sku := range filz.CAData {
+		if _, ok := costMap[sku]; ok {
+			continue
+		}
+		// addToFBAReStk took SKUs on the Restock Report out of costMap.
+		if _, ok := filz.FBARestock[sku]; ok {
+			continue
+		}
+		filz.reject(sku, rejNoSkuVault, nil)
+	}
+
 	for sku, svD := range costMap {
 		estPrice := filz.AMZViews[sku].OrdProdSales / float64(filz.AMZViews[sku].UnitsOrdered)
 		if math.IsNaN(estPrice) {
 			estPrice = 0
 		}
-		estFees := getFees(sku, estPrice, svD.Class)
-		totalCost := svD.Cost + estFees
+		// rolled up children sell at the parent's price and are judged
+		// on the parent's units.
+		qtySold := filz.CAData[sku].QtySold
+		parent, rolled := filz.parents[sku]
+		if rolled {
+			qtySold = parent.QtySold
+			if estPrice == 0 {
+				estPrice = parent.price()
+			}
+		}
+		rule := e.rules.resolve(svD.Brand, svD.Class)
+		if qtySold < rule.Topseller {
+			filz.reject(sku, rejBelowTopseller, map[string]float64{
+				"QtySold":   float64(qtySold),
+				"Topseller": float64(rule.Topseller),
+			})
+			continue
+		}
+
+		estFees := e.getFees(estPrice, svD, filz.daysSupply(sku), rule)
+		totalCost := svD.Cost + estFees.Total
 		prof := estPrice - totalCost
 
-		if prof < rules.Profit && !sugPriceUser || estPrice == 0.00 {
-			delete(filz.CAData, sku)
+		if estPrice == 0.00 {
+			filz.reject(sku, rejNoPrice, map[string]float64{
+				"OrdProdSales": filz.AMZViews[sku].OrdProdSales,
+				"UnitsOrdered": float64(filz.AMZViews[sku].UnitsOrdered),
+			})
+			continue
+		}
+		if prof < rule.Profit && !e.opts.SuggestPrice {
+			filz.reject(sku, rejLowProfit, map[string]float64{
+				"EstPrice": estPrice,
+				"Cost":     svD.Cost,
+				"Fees":     estFees.Total,
+				"EstProf":  prof,
+				"Profit":   rule.Profit,
+			})
 			continue
 		}
 
-		var suggestedPrice float64
-		if sugPriceUser {
-			suggestedPrice = totalCost + rules.Profit
+		var sugPrice priceSuggestion
+		if e.opts.SuggestPrice {
+			sugPrice = e.suggestPrice(svD, estPrice, filz.daysSupply(sku), rule)
 		}
 
 		alert, ok := filz.FBARestock[sku]
@@ -357,9 +552,9 @@ func (filz *fbaStockFiles) getSuggestion() error {
 
 		_, restock := filz.FBARestock[sku]
 
-		sugQt := filz.getSugQt(sku, svD)
+		sugQt, qtr := e.getSugQt(filz, sku, svD, rule)
 		if sugQt == 0 || sugQt == -1 {
-			delete(filz.CAData, sku)
+			filz.reject(sku, qtr.reason(), qtr.numbers())
 			continue
 		}
 
@@ -370,23 +565,74 @@ func (filz *fbaStockFiles) getSuggestion() error {
 		myMap.amzViewsH = vuMap
 		myMap.svData = svD
 		myMap.SugQt = sugQt
+		myMap.FBAQt = filz.fbaQt(sku)
+		myMap.SafetyQt = int(math.Ceil(qtr.Safety))
 		myMap.EstPrice = estPrice
 		myMap.EstProf = prof
 		myMap.Fees = estFees
 		myMap.Restock = restock
-		myMap.SugPrice = suggestedPrice
+		myMap.SugPrice = sugPrice.Price
+		myMap.SugProf = sugPrice.Prof
+		myMap.PriceBasis = sugPrice.Basis
+		myMap.Override = rule.Override
+		if rolled {
+			myMap.Parent = &parent
+		}
+		if e.opts.Explain {
+			myMap.Trace = &skuTrace{
+				Rule:      rule,
+				EstPrice:  estPrice,
+				TotalCost: totalCost,
+				EstProf:   prof,
+				Qt:        qtr,
+			}
+		}
 
 		filz.CAData[sku] = myMap
 	}
 
+	e.allocateCapacity(filz)
+	filz.budget = e.allocateBudget(filz)
+
 	return nil
 }
 
-func (filz *fbaStockFiles) getSugQt(sku string, svd svData) int {
-	days := filz.CAData[sku].ReportEndDate.Sub(filz.CAData[sku].ReportStartDate).Hours() / 24
-	qt := int((float64(filz.CAData[sku].top.QtySold)/float64(days))*float64(rules.DaysCoverd)*rules.SalesMultiplier + 0.5)
-	if qt == 1 {
-		qt = 2
+// getSugQt works out how many units of sku to send and how it got there.
+func (e *engine) getSugQt(filz *fbaStockFiles, sku string, svd svData, rule skuRule) (int, qtTrace) {
+	daily := filz.dailySales(sku)
+	tr := qtTrace{
+		Mode:       rule.QtMode,
+		Forecast:   rule.Forecast,
+		DailySales: daily,
+		Days:       float64(rule.DaysCoverd),
+		Season:     1,
+		Available:  svd.AvailableQt,
+	}
+	if rule.QtMode == qtSafety {
+		tr.Days = float64(rule.LeadTimeDays + rule.DaysCoverd)
+	}
+	if rule.QtMode == qtSafety || rule.NetFBA {
+		tr.FBAQt = filz.fbaQt(sku)
+	}
+	if si, ok := filz.seasons[seasonGroup(svd, e.rules.Seasonality.GroupBy)]; ok && rule.Forecast == forecastSeasonal {
+		tr.Season = filz.seasonFactor(sku, si, time.Now(), tr.Days)
+	}
+	tr.Demand = daily * rule.SalesMultiplier * tr.Days * tr.Season
+
+	var qt int
+	switch rule.QtMode {
+	case qtSafety:
+		tr.Safety = filz.safetyStock(sku, daily, rule)
+		qt = int(math.Ceil(tr.Demand + tr.Safety))
+	default:
+		qt = int(tr.Demand + 0.5)
+	}
+	tr.Target = qt
+	qt -= tr.FBAQt
+	tr.Need = qt
+
+	if qt > 0 && qt < minQt {
+		qt = minQt
 	}
 
 	available := svd.AvailableQt
@@ -394,26 +640,44 @@ func (filz *fbaStockFiles) getSugQt(sku string, svd svData) int {
 		qt = available
 	}
 
+	tr.SVInbound = svd.InboundQt
 	qt -= svd.InboundQt
-	if qt < 2 {
+	if qt < minQt {
 		qt = 0
 	}
+	tr.SugQt = qt
 
-	return qt
+	return qt, tr
 }
 
-// getFees estimates fees for FBA.
-func getFees(sku string, price float64, class string) float64 {
-	fees := rules.Fees
-	classFee, ok := fees.FBAClass[class]
-	if !ok {
-		classFee = fees.FBAClass["default"]
+// fbaQt is what Amazon already holds or has coming in for sku:
+// available, reserved and inbound units. Unfulfillable units are left
+// out as they can't be sold.
+func (filz *fbaStockFiles) fbaQt(sku string) int {
+	fba := filz.fbaStock[sku]
+	return fba.Available + fba.Reserved + fba.Inbound
+}
+
+// daysSupply is how many days of sales Amazon holds for sku.
+func (filz *fbaStockFiles) daysSupply(sku string) float64 {
+	daily := filz.dailySales(sku)
+	if daily <= 0 {
+		return math.Inf(1)
 	}
-	mrktFee := price * fees.FeePercentage
-	return mrktFee + classFee
+	return float64(filz.fbaQt(sku)) / daily
 }
 
-func (filz *fbaStockFiles) getSvData() (svDatas, error) {
+// dailySales is the SKU's average units sold a day over the days its
+// CA data covers.
+func (filz *fbaStockFiles) dailySales(sku string) float64 {
+	days := filz.caDays[sku]
+	if days <= 0 {
+		days = filz.CAData[sku].ReportEndDate.Sub(filz.CAData[sku].ReportStartDate).Hours() / 24
+	}
+	return float64(filz.CAData[sku].top.QtySold) / float64(days)
+}
+
+func (e *engine) getSvData(filz *fbaStockFiles) (svDatas, error) {
 	skus := []string{}
 	for sku := range filz.CAData {
 		skus = append(skus, sku)
@@ -423,63 +687,126 @@ func (filz *fbaStockFiles) getSvData() (svDatas, error) {
 		skus = append(skus, fsku)
 	}
 
-	sv := skuvault.NewEnvCredSession()
+	svD := e.svd
+	if svD == nil {
+		var err error
+		svD, err = e.fetchSvData(skus)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		svD = make(svDatas)
+		for _, sku := range skus {
+			if data, ok := e.svd[sku]; ok {
+				svD[sku] = data
+			}
+		}
+	}
+
+	if len(skus) != len(svD) {
+		stdLog.Println(`skus are off by:`, len(skus)-len(svD))
+	}
+
+	newSVD := filz.addToFBAReStk(svD)
+
+	return newSVD, nil
+}
+
+// skus lists every SKU in the CA data, Business Report and Restock
+// Report, for fetching SKU Vault data once for several runs.
+func (filz *fbaStockFiles) skus() []string {
+	seen := map[string]bool{}
+	skus := []string{}
+	add := func(sku string) {
+		if !seen[sku] {
+			seen[sku] = true
+			skus = append(skus, sku)
+		}
+	}
+	for sku := range filz.caRows {
+		add(sku)
+	}
+	for sku := range filz.CAData {
+		add(sku)
+	}
+	for sku := range filz.AMZViews {
+		add(sku)
+	}
+	for sku := range filz.parentOf {
+		add(sku)
+	}
+	for sku := range filz.FBARestock {
+		add(sku)
+	}
+	return skus
+}
 
+// fetchSvData gets the SKU Vault products of skus.
+func (e *engine) fetchSvData(skus []string) (svDatas, error) {
 	prod := &products.GetProducts{
 		PageSize:    10000,
 		ProductSKUs: skus,
 	}
 
-	resp, err := sv.Products.GetProducts(prod)
+	resp, err := e.sv.Products.GetProducts(prod)
 	if err != nil {
 		return nil, err
 	}
 
 	svD := make(svDatas)
 	for _, prod := range resp.Products {
-		svD[prod.Sku] = svData{
+		data := svData{
 			Cost:        prod.Cost,
 			Class:       prod.Classification,
 			UPC:         prod.Code,
 			Brand:       prod.Brand,
 			AvailableQt: prod.QuantityAvailable,
 			SvTitle:     prod.Description,
-			InboundQt:   prod.QuantityInbound}
-	}
-
-	if len(skus) != len(svD) {
-		stdLog.Println(`skus are off by:`, len(skus)-len(svD))
+			InboundQt:   prod.QuantityInbound,
+			RetailPrice: prod.RetailPrice,
+			Weight:      poundsFrom(prod.WeightValue, prod.WeightUnit)}
+		for _, attr := range prod.Attributes {
+			val, _ := strconv.ParseFloat(strings.TrimSpace(attr.Value), 64)
+			switch attr.Name {
+			case attrLength:
+				data.Length = val
+			case attrWidth:
+				data.Width = val
+			case attrHeight:
+				data.Height = val
+			case attrCategory:
+				data.Category = attr.Value
+			}
+		}
+		svD[prod.Sku] = data
 	}
-
-	newSVD := filz.addToFBAReStk(svD)
-
-	return newSVD, nil
+	return svD, nil
 }
 
-func getSettings(id string, d storage.DriverService) error {
-	res, err := d.GetFile(id)
+func getSettings(file reportFile) (*rulesFile, error) {
+	body, err := file.open()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	defer res.Body.Close()
-	err = json.NewDecoder(res.Body).Decode(rules)
+	defer body.Close()
+	rl, err := loadRules(body)
 	if err != nil {
-		return err
+		return nil, errors.New(file.Name + `: ` + err.Error())
 	}
 
-	return nil
+	return rl, nil
 }
 
-func getData(id string, d storage.DriverService) ([][]string, error) {
-	res, err := d.GetFile(id)
+func getData(file reportFile) ([][]string, error) {
+	body, err := file.open()
 	if err != nil {
 		return nil, err
 	}
 
-	defer res.Body.Close()
+	defer body.Close()
 
-	c := csv.NewReader(res.Body)
+	c := csv.NewReader(body)
 	c.LazyQuotes = true
 	cdata, err := c.ReadAll()
 	if err != nil {
@@ -490,23 +817,27 @@ func getData(id string, d storage.DriverService) ([][]string, error) {
 
 func (filz *fbaStockFiles) mapDataCA(data []top) {
 	ca := make(map[string]topSellerH)
+	all := make(map[string]top)
 	for _, row := range data {
 		sku := row.SKU
-		if row.QtySold < rules.Topseller {
-			continue
-		}
+		all[sku] = row
 		ca[sku] = topSellerH{
 			top: row,
 		}
 	}
 	filz.CAData = ca
+	filz.caRows = all
 }
 
 func (filz *fbaStockFiles) mapDataView(data []amzViewsH) {
 	vu := make(map[string]amzViewsH)
+	parentOf := make(map[string]string)
 
 	for _, row := range data {
 		sku := row.SKU
+		if row.Parent != "" {
+			parentOf[sku] = row.Parent
+		}
 		if row.Sessions == 0 {
 			continue
 		}
@@ -515,12 +846,15 @@ func (filz *fbaStockFiles) mapDataView(data []amzViewsH) {
 	}
 
 	filz.AMZViews = vu
+	filz.parentOf = parentOf
 }
 
 func (filz *fbaStockFiles) mapDataRestock(data []fbaRestockF) {
 	rs := make(map[string]fbaRestockH)
+	all := make(map[string]fbaRestockF)
 
 	for _, row := range data {
+		all[row.SKU] = row
 		new := fbaRestockH{}
 		if row.Alert == "" && row.RecQt == 0 {
 			continue
@@ -530,5 +864,6 @@ func (filz *fbaStockFiles) mapDataRestock(data []fbaRestockF) {
 		rs[sku] = new
 	}
 	filz.FBARestock = rs
+	filz.fbaStock = all
 	return
 }
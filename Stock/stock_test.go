@@ -0,0 +1,58 @@
+package stock
+
+import "testing"
+
+func TestGetSugQt(t *testing.T) {
+	coverage := skuRule{DaysCoverd: 30, SalesMultiplier: 1, QtMode: qtCoverage, Forecast: forecastFlat}
+	netFBA := coverage
+	netFBA.NetFBA = true
+	// a 0.5 service level has no safety stock, so safety is lead time
+	// and coverage demand less FBA stock.
+	safety := skuRule{DaysCoverd: 30, LeadTimeDays: 10, SalesMultiplier: 1, QtMode: qtSafety, ServiceLevel: 0.5, Forecast: forecastFlat}
+
+	tests := []struct {
+		name    string
+		sold    int
+		fba     fbaRestockF
+		svd     svData
+		rule    skuRule
+		want    int
+		reason  string
+		traceQt int
+	}{
+		{name: "coverage", sold: 30, svd: svData{AvailableQt: 100}, rule: coverage, want: 30},
+		{name: "coverage leaves FBA alone", sold: 30, fba: fbaRestockF{Available: 10}, svd: svData{AvailableQt: 100}, rule: coverage, want: 30},
+		{name: "coverage NetFBA", sold: 30, fba: fbaRestockF{Available: 5, Reserved: 3, Inbound: 2}, svd: svData{AvailableQt: 100}, rule: netFBA, want: 20, traceQt: 10},
+		{name: "multiplier", sold: 30, svd: svData{AvailableQt: 100}, rule: skuRule{DaysCoverd: 30, SalesMultiplier: 1.5, QtMode: qtCoverage}, want: 45},
+		{name: "SV inbound", sold: 30, svd: svData{AvailableQt: 100, InboundQt: 5}, rule: coverage, want: 25},
+		{name: "capped at available", sold: 30, svd: svData{AvailableQt: 12}, rule: coverage, want: 12},
+		{name: "one unit is minQt", sold: 1, svd: svData{AvailableQt: 100}, rule: coverage, want: minQt},
+		{name: "inbound leaves under minQt", sold: 30, svd: svData{AvailableQt: 100, InboundQt: 29}, rule: coverage, want: 0, reason: rejInboundCovers},
+		{name: "inbound covers", sold: 30, svd: svData{AvailableQt: 100, InboundQt: 30}, rule: coverage, want: 0, reason: rejInboundCovers},
+		{name: "no stock", sold: 30, svd: svData{}, rule: coverage, want: 0, reason: rejNoStock},
+		{name: "no sales", sold: 0, svd: svData{AvailableQt: 100}, rule: coverage, want: 0, reason: rejBelowMinQt},
+		{name: "safety", sold: 30, fba: fbaRestockF{Available: 10}, svd: svData{AvailableQt: 100, InboundQt: 5}, rule: safety, want: 25, traceQt: 10},
+		{name: "safety FBA covered", sold: 30, fba: fbaRestockF{Available: 30, Inbound: 15}, svd: svData{AvailableQt: 100}, rule: safety, want: 0, reason: rejFBACovered, traceQt: 45},
+	}
+	e := &engine{rules: &rulesFile{}}
+	for _, tt := range tests {
+		filz := &fbaStockFiles{
+			CAData:   map[string]topSellerH{"A": {top: top{SKU: "A", QtySold: tt.sold}}},
+			caDays:   map[string]float64{"A": 30},
+			fbaStock: map[string]fbaRestockF{"A": tt.fba},
+		}
+		got, tr := e.getSugQt(filz, "A", tt.svd, tt.rule)
+		if got != tt.want {
+			t.Errorf("%s: getSugQt = %d, want %d (trace %+v)", tt.name, got, tt.want, tr)
+		}
+		if tr.SugQt != got {
+			t.Errorf("%s: trace SugQt = %d, want %d", tt.name, tr.SugQt, got)
+		}
+		if tr.FBAQt != tt.traceQt {
+			t.Errorf("%s: trace FBAQt = %d, want %d", tt.name, tr.FBAQt, tt.traceQt)
+		}
+		if got == 0 && tr.reason() != tt.reason {
+			t.Errorf("%s: reason = %s, want %s", tt.name, tr.reason(), tt.reason)
+		}
+	}
+}